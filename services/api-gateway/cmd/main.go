@@ -1,10 +1,12 @@
 package main
 
 import (
+    "context"
     "log"
 
     "github.com/gin-gonic/gin"
     "github.com/lucas/api-gateway/routes"
+    "github.com/lucas/shared/consumer"
     "github.com/lucas/shared/utils"
 )
 
@@ -13,9 +15,28 @@ func main() {
 
     router := gin.Default()
 
+    // Client IPs are forwarded to services for throttling, so X-Forwarded-For
+    // is only honoured when set by one of these proxies
+    if err := router.SetTrustedProxies(utils.GetEnvListOrDefault("TRUSTED_PROXIES", nil)); err != nil {
+        log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+    }
+
+    // Requests in progress are answered on SIGTERM before the RPC client
+    // and the other consumers stop
+    ctx, stop := utils.ShutdownContext()
+    defer stop()
+    consumers, stopConsumers := context.WithCancel(context.Background())
+    background := consumer.NewGroup(consumers)
+
     // Setup all routes
-    routes.SetupRoutes(router)
+    routes.SetupRoutes(router, background)
 
     log.Printf("API Gateway starting on port %s", port)
-    log.Fatal(router.Run(":" + port))
+    if err := utils.ServeHTTP(ctx, ":"+port, router); err != nil {
+        log.Fatalf("Failed to start HTTP server: %v", err)
+    }
+
+    stopConsumers()
+    background.Wait()
+    log.Printf("API Gateway stopped")
 }
\ No newline at end of file
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucas/shared/models"
+)
+
+// Admin user management. The acting admin is taken from the verified token
+// and forwarded for user-service's audit trail.
+
+func (u *UserHandler) SearchUsers(c *gin.Context) {
+
+	log.Printf("Search users request received")
+
+	var req models.SearchUsersRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Limit and offset must be numbers", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	response, ok := u.sendRequest(c, "search_users", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) DisableUser(c *gin.Context) {
+
+	log.Printf("Disable user request received")
+
+	var req models.DisableUserRequest
+	// The reason is optional, so an empty body is fine
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request format", Code: models.ErrCodeInvalidRequest})
+			return
+		}
+	}
+
+	req.ActorID = c.GetString("user_id")
+	req.UserID = c.Param("id")
+
+	response, ok := u.sendRequest(c, "disable_user", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) EnableUser(c *gin.Context) {
+	u.adminUserAction(c, "enable_user")
+}
+
+func (u *UserHandler) SetUserRoles(c *gin.Context) {
+
+	log.Printf("Set user roles request received")
+
+	var req models.SetUserRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Roles are required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	req.ActorID = c.GetString("user_id")
+	req.UserID = c.Param("id")
+
+	response, ok := u.sendRequest(c, "set_user_roles", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) ForcePasswordReset(c *gin.Context) {
+	u.adminUserAction(c, "force_password_reset")
+}
+
+func (u *UserHandler) ForceLogout(c *gin.Context) {
+	u.adminUserAction(c, "force_logout")
+}
+
+func (u *UserHandler) ListAudit(c *gin.Context) {
+
+	log.Printf("List audit request received")
+
+	var req models.ListAuditRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Limit and offset must be numbers", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	response, ok := u.sendRequest(c, "list_audit", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+// adminUserAction forwards an action that only needs the target user from the path
+func (u *UserHandler) adminUserAction(c *gin.Context, action string) {
+
+	log.Printf("Admin %s request received", action)
+
+	req := models.AdminUserRequest{
+		ActorID: c.GetString("user_id"),
+		UserID:  c.Param("id"),
+	}
+
+	response, ok := u.sendRequest(c, action, req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucas/shared/consumer"
+	"github.com/lucas/shared/models"
+	"github.com/lucas/shared/utils"
+)
+
+// DeadLetterHandler lets admins inspect the messages consumers gave up on and
+// hand them back once the cause is fixed.
+type DeadLetterHandler struct {
+	broker string
+}
+
+func NewDeadLetterHandler() *DeadLetterHandler {
+	return &DeadLetterHandler{broker: utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")}
+}
+
+func (h *DeadLetterHandler) ListTopics(c *gin.Context) {
+
+	topics, err := consumer.DeadLetterTopics(c.Request.Context(), h.broker)
+	if err != nil {
+		log.Printf("Failed to list dead-letter topics: %v", err)
+		c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{Error: "Failed to reach Kafka", Code: models.ErrCodeServiceUnavailable})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"topics": topics})
+}
+
+func (h *DeadLetterHandler) List(c *gin.Context) {
+
+	var req struct {
+		Limit int `form:"limit"`
+	}
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Limit must be a number", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+	if req.Limit <= 0 || req.Limit > 500 {
+		req.Limit = 50
+	}
+
+	topic := c.Param("topic")
+	letters, err := consumer.ListDeadLetters(c.Request.Context(), h.broker, topic, req.Limit)
+	if err != nil {
+		h.fail(c, topic, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"dead_letters": letters})
+}
+
+func (h *DeadLetterHandler) Replay(c *gin.Context) {
+
+	topic := c.Param("topic")
+	partition, partitionErr := strconv.Atoi(c.Param("partition"))
+	offset, offsetErr := strconv.ParseInt(c.Param("offset"), 10, 64)
+	if partitionErr != nil || offsetErr != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Partition and offset must be numbers", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	log.Printf("Admin %s replaying dead letter %s[%d]@%d", c.GetString("user_id"), topic, partition, offset)
+
+	if err := consumer.ReplayDeadLetter(c.Request.Context(), h.broker, topic, partition, offset); err != nil {
+		h.fail(c, topic, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Dead letter replayed"})
+}
+
+func (h *DeadLetterHandler) fail(c *gin.Context, topic string, err error) {
+	if errors.Is(err, consumer.ErrDeadLetterNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{Error: "Dead letter not found", Code: models.ErrCodeDeadLetterNotFound})
+		return
+	}
+
+	log.Printf("Failed to read dead-letter topic %s: %v", topic, err)
+	c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{Error: "Failed to reach Kafka", Code: models.ErrCodeServiceUnavailable})
+}
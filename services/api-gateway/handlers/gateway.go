@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lucas/shared/health"
+	"github.com/lucas/shared/models"
 	"github.com/lucas/shared/utils"
 	"github.com/segmentio/kafka-go"
 )
@@ -23,7 +25,7 @@ func NewGatewayHandler() *GatewayHandler {
 	return &GatewayHandler{
 		kafkaWriter: &kafka.Writer{
 			Addr:     kafka.TCP(broker),
-			Topic:    "service-ping",
+			Topic:    health.TopicPing,
 			Balancer: &kafka.LeastBytes{},
 		},
 	}
@@ -49,7 +51,7 @@ func (h *GatewayHandler) PingServices(c *gin.Context) {
 	// Create reader for this specific request
 	kafkaReader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:     []string{broker},
-		Topic:       "service-pong",
+		Topic:       health.TopicPong,
 		GroupID:     groupID,
 		StartOffset: kafka.LastOffset, // Read only new messages
 		MinBytes:    1,
@@ -70,6 +72,7 @@ func (h *GatewayHandler) PingServices(c *gin.Context) {
 			cancel()
 			c.JSON(http.StatusServiceUnavailable, gin.H{
 				"error":   "Failed to communicate with services",
+				"code":    models.ErrCodeServiceUnavailable,
 				"details": err.Error(),
 			})
 			return
@@ -3,41 +3,40 @@ package handlers
 import (
 	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
+	"github.com/lucas/shared/consumer"
 	"github.com/lucas/shared/models"
+	"github.com/lucas/shared/rpc"
 	"github.com/lucas/shared/utils"
-	"github.com/segmentio/kafka-go"
 )
 
+// jsonContentType is the content type of user-service replies, which are
+// forwarded to the client as they are
+const jsonContentType = "application/json; charset=utf-8"
+
 type UserHandler struct {
-	kafkaWriter *kafka.Writer
-	kafkaReader *kafka.Reader
+	client *rpc.Client
 }
 
-func NewUserHandler() *UserHandler {
+// NewUserHandler returns a handler whose RPC client consumes replies in background.
+func NewUserHandler(background *consumer.Group) *UserHandler {
 	broker := utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")
 
-	log.Printf("Initializing UserHandler with Kafka broker: %s", broker)
+	// Each gateway instance gets its own reply topic so user-service answers
+	// reach the replica that is actually waiting for them
+	replyTopic := "api-gateway-replies." + utils.GetInstanceID()
 
-	return &UserHandler{
-		kafkaWriter: &kafka.Writer{
-			Addr:     kafka.TCP(broker),
-			Topic:    "user-requests",
-			Balancer: &kafka.LeastBytes{},
-		},
-		kafkaReader: kafka.NewReader(kafka.ReaderConfig{
-			Brokers:     []string{broker},
-			Topic:       "user-responses",
-			GroupID:     "api-gateway-user-group",
-			StartOffset: kafka.FirstOffset, // Changed to FirstOffset to ensure we don't miss messages
-		}),
-	}
+	log.Printf("Initializing UserHandler with Kafka broker: %s, reply topic: %s", broker, replyTopic)
+
+	client := rpc.NewClient(broker, replyTopic, 30*time.Second)
+	background.Go(client.Run)
+
+	return &UserHandler{client: client}
 }
 
 func (u *UserHandler) Register(c *gin.Context) {
@@ -47,51 +46,25 @@ func (u *UserHandler) Register(c *gin.Context) {
 	// 1. Validate request structure (Gateway responsibility)
 	var req models.RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request format", Code: models.ErrCodeInvalidRequest})
 		return
 	}
 
 	// 2. Basic validation (Gateway responsibility)
 	if req.Email == "" || req.Password == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email and password are required"})
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Email and password are required", Code: models.ErrCodeInvalidRequest})
 		return
 	}
 
-	// 3. Create message with correlation ID for response tracking
-	correlationID := uuid.New().String()
-	message := models.UserServiceMessage{
-		CorrelationID: correlationID,
-		Action:        "register",
-		Data:          req,
-		Timestamp:     time.Now(),
-	}
-
-	// 4. Send message to user-service (Gateway responsibility)
-	messageBytes, _ := json.Marshal(message)
-	err := u.kafkaWriter.WriteMessages(context.Background(),
-		kafka.Message{
-			Key:   []byte(correlationID),
-			Value: messageBytes,
-		},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+	// 3. Send message to user-service and await response (Gateway responsibility)
+	response, ok := u.sendRequest(c, "register", req, 2*time.Minute)
+	if !ok {
 		return
 	}
 
-	log.Printf("Register request sent via kafka: %s", string(messageBytes))
+	// 4. Forward response (Gateway responsibility)
+	c.Data(http.StatusCreated, jsonContentType, response)
 
-	// 5. Await response (Gateway responsibility)
-	response, err := u.waitForResponse(correlationID, 2*time.Minute)
-	if err != nil {
-		c.JSON(http.StatusRequestTimeout, gin.H{"error": "Request timeout"})
-		return
-	}
-
-	// 6. Forward response (Gateway responsibility)
-	c.JSON(response.StatusCode, response.Data)
-
-	log.Printf("Register response received: %s", response.Data)
 }
 
 func (u *UserHandler) Login(c *gin.Context) {
@@ -100,136 +73,521 @@ func (u *UserHandler) Login(c *gin.Context) {
 
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request format"})
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request format", Code: models.ErrCodeInvalidRequest})
 		return
 	}
 
 	if req.Email == "" || req.Password == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email and password are required"})
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Email and password are required", Code: models.ErrCodeInvalidRequest})
 		return
 	}
 
-	correlationID := uuid.New().String()
-	message := models.UserServiceMessage{
-		CorrelationID: correlationID,
-		Action:        "login",
-		Data:          req,
-		Timestamp:     time.Now(),
+	log.Printf("Sending login request message via kafka")
+	response, ok := u.sendRequest(c, "login", req, 30*time.Second)
+	if !ok {
+		return
 	}
 
-	log.Printf("Sending login request message via kafka")
-	messageBytes, _ := json.Marshal(message)
-	err := u.kafkaWriter.WriteMessages(context.Background(),
-		kafka.Message{
-			Key:   []byte(correlationID),
-			Value: messageBytes,
-		},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+	c.Data(loginStatus(response), jsonContentType, response)
+}
+
+func (u *UserHandler) Refresh(c *gin.Context) {
+
+	log.Printf("Refresh request received")
+
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Refresh token is required", Code: models.ErrCodeInvalidRequest})
 		return
 	}
 
-	log.Printf("Waiting login response from kafka")
-	response, err := u.waitForResponse(correlationID, 30*time.Second)
-	if err != nil {
-		c.JSON(http.StatusRequestTimeout, gin.H{"error": "Request timeout"})
+	response, ok := u.sendRequest(c, "refresh", req, 30*time.Second)
+	if !ok {
 		return
 	}
 
-	c.JSON(response.StatusCode, response.Data)
-	log.Printf("Login response received: %s", response.Data)
+	c.Data(http.StatusOK, jsonContentType, response)
 }
 
 func (u *UserHandler) GetProfile(c *gin.Context) {
-	// Extract user ID from JWT token or path parameter
-	userID := c.Param("id")
+	// The profile always belongs to the authenticated user
+	userID := c.GetString("user_id")
 	if userID == "" {
-		// Try to get from JWT token (you'd implement JWT middleware)
-		userID = c.GetString("user_id")
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Authentication required", Code: models.ErrCodeUnauthenticated})
+		return
 	}
 
-	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID required"})
+	response, ok := u.sendRequest(c, "get_profile", map[string]string{"user_id": userID}, 30*time.Second)
+	if !ok {
 		return
 	}
 
-	correlationID := uuid.New().String()
-	message := models.UserServiceMessage{
-		CorrelationID: correlationID,
-		Action:        "get_profile",
-		Data:          map[string]string{"user_id": userID},
-		Timestamp:     time.Now(),
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) ListSessions(c *gin.Context) {
+
+	log.Printf("List sessions request received")
+
+	req := models.ListSessionsRequest{
+		UserID:    c.GetString("user_id"),
+		SessionID: c.GetString("session_id"),
 	}
 
-	messageBytes, _ := json.Marshal(message)
-	err := u.kafkaWriter.WriteMessages(context.Background(),
-		kafka.Message{
-			Key:   []byte(correlationID),
-			Value: messageBytes,
-		},
-	)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+	response, ok := u.sendRequest(c, "list_sessions", req, 30*time.Second)
+	if !ok {
 		return
 	}
 
-	response, err := u.waitForResponse(correlationID, 120*time.Second)
-	if err != nil {
-		c.JSON(http.StatusRequestTimeout, gin.H{"error": "Request timeout"})
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) RevokeSession(c *gin.Context) {
+
+	log.Printf("Revoke session request received")
+
+	req := models.RevokeSessionRequest{
+		UserID:    c.GetString("user_id"),
+		SessionID: c.Param("id"),
+	}
+
+	response, ok := u.sendRequest(c, "revoke_session", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) UpdateProfile(c *gin.Context) {
+
+	log.Printf("Update profile request received")
+
+	var req models.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request format", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	if req.Name == nil && req.Email == nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Nothing to update", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	// Never trust a user ID from the body
+	req.UserID = c.GetString("user_id")
+
+	response, ok := u.sendRequest(c, "update_profile", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) ChangePassword(c *gin.Context) {
+
+	log.Printf("Change password request received")
+
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Current and new password are required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	req.UserID = c.GetString("user_id")
+
+	response, ok := u.sendRequest(c, "change_password", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) ExportData(c *gin.Context) {
+
+	log.Printf("Export data request received")
+
+	req := models.ExportDataRequest{UserID: c.GetString("user_id")}
+
+	response, ok := u.sendRequest(c, "export_data", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="personal-data.json"`)
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) DeleteAccount(c *gin.Context) {
+
+	log.Printf("Delete account request received")
+
+	var req models.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Password is required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	req.UserID = c.GetString("user_id")
+
+	response, ok := u.sendRequest(c, "delete_account", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) VerifyEmail(c *gin.Context) {
+
+	log.Printf("Verify email request received")
+
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Token is required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	response, ok := u.sendRequest(c, "verify_email", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) ResendVerification(c *gin.Context) {
+
+	log.Printf("Resend verification request received")
+
+	var req models.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Email is required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	response, ok := u.sendRequest(c, "resend_verification", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusAccepted, jsonContentType, response)
+}
+
+func (u *UserHandler) ForgotPassword(c *gin.Context) {
+
+	log.Printf("Forgot password request received")
+
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Email is required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	response, ok := u.sendRequest(c, "forgot_password", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusAccepted, jsonContentType, response)
+}
+
+func (u *UserHandler) ResetPassword(c *gin.Context) {
+
+	log.Printf("Reset password request received")
+
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Token and new password are required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	response, ok := u.sendRequest(c, "reset_password", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) OAuthStart(c *gin.Context) {
+
+	log.Printf("OAuth start request received for provider: %s", c.Param("provider"))
+
+	req := models.OAuthStartRequest{
+		Provider: c.Param("provider"),
+	}
+
+	response, ok := u.sendRequest(c, "oauth_start", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+// LinkIdentity starts linking an identity provider to the signed-in user. The
+// provider redirects back to OAuthCallback like a sign-in.
+func (u *UserHandler) LinkIdentity(c *gin.Context) {
+
+	log.Printf("Link identity request received for provider: %s", c.Param("provider"))
+
+	var req models.LinkIdentityRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Password is required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	req.UserID = c.GetString("user_id")
+	req.Provider = c.Param("provider")
+
+	response, ok := u.sendRequest(c, "link_identity", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+// OAuthCallback is the redirect URI registered with identity providers.
+func (u *UserHandler) OAuthCallback(c *gin.Context) {
+
+	log.Printf("OAuth callback received for provider: %s", c.Param("provider"))
+
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Sign-in was cancelled or refused: " + providerError, Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	var req models.OAuthCallbackRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Code and state are required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	req.Provider = c.Param("provider")
+
+	response, ok := u.sendRequest(c, "oauth_callback", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(loginStatus(response), jsonContentType, response)
+}
+
+func (u *UserHandler) VerifyMFA(c *gin.Context) {
+
+	log.Printf("Verify MFA request received")
+
+	var req models.VerifyMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "MFA token and code are required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	response, ok := u.sendRequest(c, "verify_mfa", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(loginStatus(response), jsonContentType, response)
+}
+
+func (u *UserHandler) EnrollMFA(c *gin.Context) {
+
+	log.Printf("Enroll MFA request received")
+
+	req := models.EnrollMFARequest{
+		UserID: c.GetString("user_id"),
+	}
+
+	response, ok := u.sendRequest(c, "enroll_mfa", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) ConfirmMFA(c *gin.Context) {
+
+	log.Printf("Confirm MFA request received")
+
+	var req models.ConfirmMFARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Code is required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	req.UserID = c.GetString("user_id")
+
+	response, ok := u.sendRequest(c, "confirm_mfa", req, 30*time.Second)
+	if !ok {
 		return
 	}
 
-	c.JSON(response.StatusCode, response.Data)
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) UnlockAccount(c *gin.Context) {
+
+	log.Printf("Unlock account request received")
+
+	var req models.UnlockAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Email is required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	req.ActorID = c.GetString("user_id")
+
+	response, ok := u.sendRequest(c, "unlock_account", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
 }
 
 func (u *UserHandler) Logout(c *gin.Context) {
-	// For logout, you might just invalidate the token locally
-	// or send a message to revoke refresh tokens
-	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+
+	log.Printf("Logout request received")
+
+	// The body is optional, only all_devices is read from it
+	var body struct {
+		AllDevices bool `json:"all_devices"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Invalid request format", Code: models.ErrCodeInvalidRequest})
+			return
+		}
+	}
+
+	req := models.LogoutRequest{
+		UserID:         c.GetString("user_id"),
+		SessionID:      c.GetString("session_id"),
+		TokenID:        c.GetString("token_id"),
+		TokenExpiresAt: c.GetTime("token_expires_at"),
+		AllDevices:     body.AllDevices,
+	}
+
+	response, ok := u.sendRequest(c, "logout", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) CreateAPIKey(c *gin.Context) {
+
+	log.Printf("Create API key request received")
+
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: "Name and scopes are required", Code: models.ErrCodeInvalidRequest})
+		return
+	}
+
+	req.UserID = c.GetString("user_id")
+
+	response, ok := u.sendRequest(c, "create_api_key", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusCreated, jsonContentType, response)
+}
+
+func (u *UserHandler) ListAPIKeys(c *gin.Context) {
+
+	log.Printf("List API keys request received")
+
+	req := models.ListAPIKeysRequest{
+		UserID: c.GetString("user_id"),
+	}
+
+	response, ok := u.sendRequest(c, "list_api_keys", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
+}
+
+func (u *UserHandler) RevokeAPIKey(c *gin.Context) {
+
+	log.Printf("Revoke API key request received")
+
+	req := models.RevokeAPIKeyRequest{
+		UserID: c.GetString("user_id"),
+		KeyID:  c.Param("id"),
+	}
+
+	response, ok := u.sendRequest(c, "revoke_api_key", req, 30*time.Second)
+	if !ok {
+		return
+	}
+
+	c.Data(http.StatusOK, jsonContentType, response)
 }
 
-// Helper method to wait for Kafka response
-func (u *UserHandler) waitForResponse(correlationID string, timeout time.Duration) (*models.UserServiceResponse, error) {
+// AuthenticateAPIKey asks user-service who key belongs to. It implements
+// middleware.APIKeyAuthenticator; a nil identity means the key was rejected.
+func (u *UserHandler) AuthenticateAPIKey(ctx context.Context, key string) (*models.APIKeyIdentity, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
 
-	log.Printf("Waiting for response with correlationID: %s", correlationID)
+	identity, err := rpc.Call[models.AuthenticateAPIKeyRequest, models.APIKeyIdentity](ctx, u.client, "user-service", "authenticate_api_key", models.AuthenticateAPIKeyRequest{Key: key})
+	if err != nil {
+		var rpcErr *rpc.Error
+		if errors.As(err, &rpcErr) && rpcErr.Code == models.ErrCodeInvalidAPIKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+// sendRequest calls an action of user-service and returns its JSON reply.
+// On failure it writes the error response itself and returns false.
+func (u *UserHandler) sendRequest(c *gin.Context, action string, data any, timeout time.Duration) (json.RawMessage, bool) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
 	defer cancel()
+	ctx = rpc.WithMetadata(ctx, rpc.MetadataClientIP, c.ClientIP())
+	ctx = rpc.WithMetadata(ctx, rpc.MetadataUserAgent, c.Request.UserAgent())
 
-	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("Timeout waiting for response with correlationID: %s", correlationID)
-			return nil, fmt.Errorf("timeout waiting for response")
+	response, err := rpc.Call[any, json.RawMessage](ctx, u.client, "user-service", action, data)
+	if err != nil {
+		// Errors are answered here so every route returns the same error body
+		var rpcErr *rpc.Error
+		switch {
+		case errors.Is(err, context.Canceled):
+			// Client went away, nobody is left to read a response
+			c.Abort()
+		case errors.As(err, &rpcErr):
+			c.JSON(models.StatusForErrorCode(rpcErr.Code), models.ErrorResponse{Error: rpcErr.Message, Code: rpcErr.Code})
 		default:
-			message, err := u.kafkaReader.FetchMessage(ctx)
-			if err != nil {
-				log.Printf("Error fetching message: %v", err)
-				continue
-			}
-
-			log.Printf("Received kafka message - Key: %s, Value: %s", string(message.Key), string(message.Value))
-
-			// Check if this message is for us
-			var response models.UserServiceResponse
-			if err := json.Unmarshal(message.Value, &response); err != nil {
-				log.Printf("Failed to unmarshal response: %v", err)
-				u.kafkaReader.CommitMessages(context.Background(), message)
-				continue
-			}
-
-			log.Printf("Parsed response - CorrelationID: %s, StatusCode: %d", response.CorrelationID, response.StatusCode)
-
-			if response.CorrelationID == correlationID {
-				log.Printf("Found matching response for correlationID: %s", correlationID)
-				u.kafkaReader.CommitMessages(context.Background(), message)
-				return &response, nil
-			}
-
-			// Not our message, commit and continue
-			log.Printf("Message not for us (expected: %s, got: %s), continuing...", correlationID, response.CorrelationID)
-			u.kafkaReader.CommitMessages(context.Background(), message)
+			log.Printf("Failed to call %s: %v", action, err)
+			c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to process request", Code: models.ErrCodeInternal})
 		}
+		return nil, false
+	}
+
+	return response, true
+}
+
+// loginStatus is 201 when a login created a session and 200 when it stopped
+// at an MFA challenge.
+func loginStatus(response json.RawMessage) int {
+	var body struct {
+		MFARequired bool `json:"mfa_required"`
+	}
+	if json.Unmarshal(response, &body) == nil && body.MFARequired {
+		return http.StatusOK
 	}
+	return http.StatusCreated
 }
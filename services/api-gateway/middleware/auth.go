@@ -1,23 +1,34 @@
 package middleware
 
 import (
-    "context"
-    "net/http"
-    "strings"
-
-    "github.com/gin-gonic/gin"
-    "github.com/golang-jwt/jwt/v5"
-    "github.com/lucas/shared/utils"
-    "github.com/redis/go-redis/v9"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lucas/shared/models"
+	"github.com/lucas/shared/utils"
+	"github.com/redis/go-redis/v9"
 )
 
 type AuthMiddleware struct {
-	jwtSecret   []byte
+	keySet      *keySetCache
+	jwtIssuer   string
 	redisClient *redis.Client
+	apiKeys     APIKeyAuthenticator
+	apiKeyCache *apiKeyCache
 }
 
-func NewAuthMiddleware() *AuthMiddleware {
-	secret := utils.GetEnvOrDefault("JWT_SECRECT", "your-secret-key")
+// NewAuthMiddleware verifies bearer tokens locally and resolves API keys through apiKeys.
+func NewAuthMiddleware(apiKeys APIKeyAuthenticator) *AuthMiddleware {
+	// Verification keys are published by user-service, which holds the private keys
+	jwksURL := utils.GetEnvOrDefault("JWKS_URL", "http://user-service:8083/.well-known/jwks.json")
+	issuer := utils.GetEnvOrDefault("JWT_ISSUER", "microcommerce-user-service")
 	redisAddr := utils.GetEnvOrDefault("REDIS_ADDR", "localhost:6379")
 
 	rdb := redis.NewClient(&redis.Options{
@@ -25,8 +36,11 @@ func NewAuthMiddleware() *AuthMiddleware {
 	})
 
 	return &AuthMiddleware{
-		jwtSecret: []byte(secret),
+		keySet:      newKeySetCache(jwksURL, 5*time.Minute),
+		jwtIssuer:   issuer,
 		redisClient: rdb,
+		apiKeys:     apiKeys,
+		apiKeyCache: newAPIKeyCache(30 * time.Second),
 	}
 }
 
@@ -34,79 +48,174 @@ func (a *AuthMiddleware) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check for Authorization header
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" && c.GetHeader(APIKeyHeader) != "" {
+			a.requireAPIKey(c)
+			return
+		}
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error":"Authorization header required"})
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Authorization header required", Code: models.ErrCodeUnauthenticated})
 			c.Abort()
 			return
 		}
 
 		// Validate Bearer token format
-		tokenString := strings.TrimPrefix(authHeader, "Bearer")
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error":"Invalid authorization format"})
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid authorization format", Code: models.ErrCodeUnauthenticated})
 			c.Abort()
 			return
 		}
 
 		// Parse and validate JWT
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
-			return a.jwtSecret, nil
-		})
+		token, err := a.parseToken(tokenString)
 		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error":"Invalid token"})
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid token", Code: models.ErrCodeInvalidToken})
 			c.Abort()
 			return
 		}
 
 		claims, ok := token.Claims.(jwt.MapClaims)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error":"Invalid token claims"})
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid token claims", Code: models.ErrCodeInvalidToken})
 			c.Abort()
 			return
 		}
 
 		// Check if the token is blacklisted (logout/revoked tokens)
-		userID := claims["user_id"].(string)
-		jti := claims["jti"].(string) // JWT ID for blacklisting specific tokens
+		userID, _ := claims["user_id"].(string)
+		jti, _ := claims["jti"].(string) // JWT ID for blacklisting specific tokens
+		if userID == "" || jti == "" {
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid token claims", Code: models.ErrCodeInvalidToken})
+			c.Abort()
+			return
+		}
 
-		isBlacklisted, err := a.redisClient.Get(context.Background(), "blacklist:"+jti).Result()
-		if err != nil && isBlacklisted == "true" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+		revoked, err := a.isRevoked(c.Request.Context(), claims)
+		if err != nil {
+			// Fail closed: without Redis we can't tell a revoked token from a valid one
+			log.Printf("Failed to check token revocation: %v", err)
+			c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{Error: "Unable to verify token", Code: models.ErrCodeServiceUnavailable})
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Token has been revoked", Code: models.ErrCodeInvalidToken})
 			c.Abort()
 			return
 		}
 
 		// Set user contact for downstream handlers
-		c.Set("user_id", userID)
-		c.Set("user_email", claims["email"])
-		c.Set("user_roles", claims["roles"])
+		setClaims(c, claims)
 
 		c.Next()
 	}
 }
 
+// requireAPIKey authenticates the request by its X-API-Key header.
+func (a *AuthMiddleware) requireAPIKey(c *gin.Context) {
+	identity, err := a.authenticateAPIKey(c.Request.Context(), c.GetHeader(APIKeyHeader))
+	if err != nil {
+		log.Printf("Failed to authenticate API key: %v", err)
+		c.JSON(http.StatusServiceUnavailable, models.ErrorResponse{Error: "Unable to verify API key", Code: models.ErrCodeServiceUnavailable})
+		c.Abort()
+		return
+	}
+	if identity == nil {
+		c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid API key", Code: models.ErrCodeInvalidAPIKey})
+		c.Abort()
+		return
+	}
+
+	setAPIKeyIdentity(c, identity)
+	c.Next()
+}
+
 func (a *AuthMiddleware) OptionalAuth() gin.HandlerFunc {
-    return func(c *gin.Context) {
-        authHeader := c.GetHeader("Authorization")
-        if authHeader == "" {
-            c.Next()
-            return
-        }
-
-        // Same validation logic but don't abort on failure
-        tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-        token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-            return a.jwtSecret, nil
-        })
-
-        if err == nil && token.Valid {
-            if claims, ok := token.Claims.(jwt.MapClaims); ok {
-                c.Set("user_id", claims["user_id"])
-                c.Set("user_email", claims["email"])
-                c.Set("user_roles", claims["roles"])
-            }
-        }
-
-        c.Next()
-    }
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			if key := c.GetHeader(APIKeyHeader); key != "" {
+				if identity, err := a.authenticateAPIKey(c.Request.Context(), key); err == nil && identity != nil {
+					setAPIKeyIdentity(c, identity)
+				}
+			}
+			c.Next()
+			return
+		}
+
+		// Same validation logic but don't abort on failure
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		token, err := a.parseToken(tokenString)
+
+		if err == nil && token.Valid {
+			if claims, ok := token.Claims.(jwt.MapClaims); ok {
+				if revoked, err := a.isRevoked(c.Request.Context(), claims); err == nil && !revoked {
+					setClaims(c, claims)
+				}
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// parseToken verifies signature, algorithm, issuer and expiry of an access token.
+func (a *AuthMiddleware) parseToken(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, a.keySet.keyFunc,
+		jwt.WithValidMethods(allowedSigningMethods),
+		jwt.WithIssuer(a.jwtIssuer),
+		jwt.WithExpirationRequired(),
+	)
+}
+
+// isRevoked reports whether the token was logged out individually (its jti is
+// blacklisted), belongs to a session that no longer exists or was issued
+// before its user logged out of all devices.
+func (a *AuthMiddleware) isRevoked(ctx context.Context, claims jwt.MapClaims) (bool, error) {
+	userID, _ := claims["user_id"].(string)
+	jti, _ := claims["jti"].(string)
+
+	sessionID, _ := claims["sid"].(string)
+
+	values, err := a.redisClient.MGet(ctx, "blacklist:"+jti, "revoked_after:"+userID, "session:"+sessionID).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if values[0] != nil {
+		return true, nil
+	}
+
+	// The session was logged out or revoked from another device
+	if sessionID != "" && values[2] == nil {
+		return true, nil
+	}
+
+	// The cutoff is in Unix milliseconds; tokens issued from it on stay valid
+	if revokedAfter, ok := values[1].(string); ok {
+		cutoff, err := strconv.ParseInt(revokedAfter, 10, 64)
+		if err != nil {
+			return false, fmt.Errorf("invalid revoked_after value for user %s: %w", userID, err)
+		}
+		issuedAt, err := claims.GetIssuedAt()
+		if err != nil || issuedAt == nil || issuedAt.UnixMilli() < cutoff {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// setClaims exposes the token's identity to downstream handlers.
+func setClaims(c *gin.Context, claims jwt.MapClaims) {
+	c.Set("user_id", claims["user_id"])
+	c.Set("user_email", claims["email"])
+	c.Set("user_roles", claims["roles"])
+	c.Set("user_permissions", claims["permissions"])
+	c.Set("user_amr", claims["amr"])
+	c.Set("session_id", claims["sid"])
+	c.Set("token_id", claims["jti"])
+	if expiresAt, err := claims.GetExpirationTime(); err == nil && expiresAt != nil {
+		c.Set("token_expires_at", expiresAt.Time)
+	}
 }
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lucas/shared/jwks"
+)
+
+// allowedSigningMethods are the only algorithms accepted on access tokens.
+// Anything else (HS256, none, ...) is rejected before a key is even looked up.
+var allowedSigningMethods = []string{"RS256", "EdDSA"}
+
+// keySetCache fetches the user-service JWKS and keeps it for ttl. An unknown
+// kid triggers an early refresh so rotated keys are picked up without waiting,
+// throttled so a flood of forged kids can't hammer user-service.
+type keySetCache struct {
+	url        string
+	ttl        time.Duration
+	minRefresh time.Duration
+	httpClient *http.Client
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	algs        map[string]string
+	fetchedAt   time.Time
+	attemptedAt time.Time
+}
+
+func newKeySetCache(url string, ttl time.Duration) *keySetCache {
+	return &keySetCache{
+		url:        url,
+		ttl:        ttl,
+		minRefresh: 30 * time.Second,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// keyFunc resolves the verification key for token by its kid header.
+func (k *keySetCache) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, fmt.Errorf("token has no kid")
+	}
+
+	key, alg, ok := k.lookup(kid)
+	if !ok {
+		k.refresh(true)
+		key, alg, ok = k.lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+
+	// A key may only verify tokens of the algorithm it was published for
+	if alg != "" && token.Method.Alg() != alg {
+		return nil, fmt.Errorf("unexpected signing method %s for key %q", token.Method.Alg(), kid)
+	}
+
+	return key, nil
+}
+
+func (k *keySetCache) lookup(kid string) (crypto.PublicKey, string, bool) {
+	k.mu.RLock()
+	stale := time.Since(k.fetchedAt) > k.ttl
+	k.mu.RUnlock()
+
+	if stale {
+		k.refresh(false)
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[kid]
+	return key, k.algs[kid], ok
+}
+
+// refresh reloads the key set once it is older than ttl, or regardless of age
+// when force is set. Attempts closer together than minRefresh are skipped and
+// on failure the previous key set is kept.
+func (k *keySetCache) refresh(force bool) {
+	k.mu.Lock()
+	if time.Since(k.attemptedAt) < k.minRefresh || (!force && time.Since(k.fetchedAt) <= k.ttl) {
+		k.mu.Unlock()
+		return
+	}
+	k.attemptedAt = time.Now()
+	k.mu.Unlock()
+
+	set, err := k.fetch()
+	if err != nil {
+		log.Printf("Failed to fetch JWKS from %s: %v", k.url, err)
+		return
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	algs := make(map[string]string, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			log.Printf("Skipping JWKS key %s: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+		algs[jwk.Kid] = jwk.Alg
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.algs = algs
+	k.fetchedAt = time.Now()
+	k.mu.Unlock()
+
+	log.Printf("Loaded %d keys from JWKS", len(keys))
+}
+
+func (k *keySetCache) fetch() (*jwks.Set, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var set jwks.Set
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS document: %w", err)
+	}
+	return &set, nil
+}
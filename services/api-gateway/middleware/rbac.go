@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lucas/shared/models"
+)
+
+// Roles known to user-service
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+)
+
+// RequireRole lets the request through when the caller holds at least one of
+// roles. It must run after RequireAuth, which puts the token's roles in the context.
+func (a *AuthMiddleware) RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_id") == "" {
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Authentication required", Code: models.ErrCodeUnauthenticated})
+			c.Abort()
+			return
+		}
+
+		held := contextStrings(c, "user_roles")
+		for _, role := range roles {
+			if slices.Contains(held, role) {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, models.ErrorResponse{Error: "Insufficient permissions", Code: models.ErrCodeForbidden})
+		c.Abort()
+	}
+}
+
+// RequirePermission lets the request through only when the caller holds every
+// one of permissions. It must run after RequireAuth.
+func (a *AuthMiddleware) RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_id") == "" {
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Authentication required", Code: models.ErrCodeUnauthenticated})
+			c.Abort()
+			return
+		}
+
+		held := contextStrings(c, "user_permissions")
+		for _, permission := range permissions {
+			if !slices.Contains(held, permission) {
+				c.JSON(http.StatusForbidden, models.ErrorResponse{Error: "Insufficient permissions", Code: models.ErrCodeForbidden})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// RequireMFA lets the request through only when the caller's session was
+// started with a second factor. It must run after RequireAuth.
+func (a *AuthMiddleware) RequireMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_id") == "" {
+			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Authentication required", Code: models.ErrCodeUnauthenticated})
+			c.Abort()
+			return
+		}
+
+		if !slices.Contains(contextStrings(c, "user_amr"), "mfa") {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{Error: "Two-factor authentication required", Code: models.ErrCodeMFARequired})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession refuses requests authenticated with an API key, for routes
+// that only make sense for an interactive login such as managing API keys.
+// It must run after RequireAuth.
+func (a *AuthMiddleware) RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("session_id") == "" {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{Error: "Not allowed with an API key", Code: models.ErrCodeForbidden})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// contextStrings reads a string list set from token claims, which decode as []any.
+func contextStrings(c *gin.Context, key string) []string {
+	value, _ := c.Get(key)
+	switch v := value.(type) {
+	case []string:
+		return v
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -1,19 +1,25 @@
 package routes
 
 import (
+	"expvar"
+
 	"github.com/gin-gonic/gin"
 	"github.com/lucas/api-gateway/handlers"
 	"github.com/lucas/api-gateway/middleware"
+	"github.com/lucas/shared/consumer"
 )
 
-func SetupRoutes(router *gin.Engine) {
+// SetupRoutes registers the gateway's routes. The Kafka consumers behind them
+// run in background.
+func SetupRoutes(router *gin.Engine, background *consumer.Group) {
 
 	// Initialize handlers
 	gatewayHandler := handlers.NewGatewayHandler()
-	userHandler := handlers.NewUserHandler()
+	userHandler := handlers.NewUserHandler(background)
+	deadLetterHandler := handlers.NewDeadLetterHandler()
 
 	// Initialize middleware
-	authMiddleware := middleware.NewAuthMiddleware()
+	authMiddleware := middleware.NewAuthMiddleware(userHandler)
 
 	// Gateway health check
 	router.GET("/", gatewayHandler.HealthCheck)
@@ -28,14 +34,57 @@ func SetupRoutes(router *gin.Engine) {
 		{
 			auth.POST("/register", userHandler.Register)
 			auth.POST("/login", userHandler.Login)
+			auth.POST("/refresh", userHandler.Refresh)
+			auth.POST("/verify-email", userHandler.VerifyEmail)
+			auth.POST("/resend-verification", userHandler.ResendVerification)
+			auth.POST("/forgot-password", userHandler.ForgotPassword)
+			auth.POST("/reset-password", userHandler.ResetPassword)
+			auth.POST("/mfa/verify", userHandler.VerifyMFA)
+			auth.GET("/oauth/:provider/start", userHandler.OAuthStart)
+			auth.GET("/oauth/:provider/callback", userHandler.OAuthCallback)
 		}
-		
+
 		// Protected user routes (auth required)
 		users := api.Group("/users")
 		users.Use(authMiddleware.RequireAuth())
+		users.Use(authMiddleware.RequireRole(middleware.RoleCustomer, middleware.RoleStaff, middleware.RoleAdmin))
 		{
 			auth.POST("/logout", authMiddleware.RequireAuth(), userHandler.Logout)
-			users.GET("/profile", userHandler.GetProfile)
+			users.GET("/profile", authMiddleware.RequirePermission("profile:read"), userHandler.GetProfile)
+			users.PATCH("/profile", authMiddleware.RequirePermission("profile:write"), userHandler.UpdateProfile)
+			users.POST("/password", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:write"), userHandler.ChangePassword)
+			users.GET("/sessions", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:read"), userHandler.ListSessions)
+			users.DELETE("/sessions/:id", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:write"), userHandler.RevokeSession)
+			users.POST("/api-keys", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:write"), userHandler.CreateAPIKey)
+			users.GET("/api-keys", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:read"), userHandler.ListAPIKeys)
+			users.DELETE("/api-keys/:id", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:write"), userHandler.RevokeAPIKey)
+			users.POST("/mfa/enroll", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:write"), userHandler.EnrollMFA)
+			users.POST("/mfa/confirm", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:write"), userHandler.ConfirmMFA)
+			users.POST("/identities/:provider", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:write"), userHandler.LinkIdentity)
+			users.GET("/me/export", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:read"), userHandler.ExportData)
+			users.DELETE("/me", authMiddleware.RequireSession(), authMiddleware.RequirePermission("profile:write"), userHandler.DeleteAccount)
+		}
+
+		// Administration routes
+		admin := api.Group("/admin")
+		admin.Use(authMiddleware.RequireAuth())
+		admin.Use(authMiddleware.RequireRole(middleware.RoleAdmin))
+		admin.Use(authMiddleware.RequireMFA())
+		{
+			admin.POST("/users/unlock", authMiddleware.RequirePermission("users:write"), userHandler.UnlockAccount)
+			admin.GET("/users", authMiddleware.RequirePermission("users:read"), userHandler.SearchUsers)
+			admin.POST("/users/:id/disable", authMiddleware.RequirePermission("users:write"), userHandler.DisableUser)
+			admin.POST("/users/:id/enable", authMiddleware.RequirePermission("users:write"), userHandler.EnableUser)
+			admin.PUT("/users/:id/roles", authMiddleware.RequirePermission("roles:write"), userHandler.SetUserRoles)
+			admin.POST("/users/:id/password-reset", authMiddleware.RequirePermission("users:write"), userHandler.ForcePasswordReset)
+			admin.POST("/users/:id/logout", authMiddleware.RequirePermission("users:write"), userHandler.ForceLogout)
+			admin.GET("/audit", authMiddleware.RequirePermission("users:read"), userHandler.ListAudit)
+			// Call counts, latencies and error codes of the gateway's RPC client
+			admin.GET("/rpc-metrics", authMiddleware.RequirePermission("services:read"), gin.WrapH(expvar.Handler()))
+			// Messages consumers gave up on after exhausting their retries
+			admin.GET("/dead-letters", authMiddleware.RequirePermission("services:read"), deadLetterHandler.ListTopics)
+			admin.GET("/dead-letters/:topic", authMiddleware.RequirePermission("services:read"), deadLetterHandler.List)
+			admin.POST("/dead-letters/:topic/:partition/:offset/replay", authMiddleware.RequirePermission("services:write"), deadLetterHandler.Replay)
 		}
 	}
 
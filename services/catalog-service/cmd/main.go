@@ -7,15 +7,33 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lucas/shared/consumer"
+	"github.com/lucas/shared/health"
+	"github.com/lucas/shared/privacy"
 	"github.com/lucas/shared/utils"
-	"github.com/segmentio/kafka-go"
 )
 
 func main() {
 	port := utils.GetEnvOrDefault("PORT", "8082")
 
-	// Start Kafka consumer in a goroutine
-	go startKafkaConsumer()
+	// Consumers stop and drain on SIGTERM
+	ctx, stop := utils.ShutdownContext()
+	defer stop()
+	background := consumer.NewGroup(ctx)
+
+	// Answer the gateway's service pings
+	broker := utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")
+	background.Go(func(ctx context.Context) {
+		health.ServePings(ctx, broker, "catalog-service", "catalog-service-group")
+	})
+
+	// Take part in personal data exports and account deletions
+	background.Go(func(ctx context.Context) {
+		privacy.ServeDataExports(ctx, broker, "catalog-service", exportUserData)
+	})
+	background.Go(func(ctx context.Context) {
+		privacy.ConsumeUserDeletions(ctx, broker, "catalog-service", purgeUserData)
+	})
 
 	// Create HTTP server for health checks
 	r := gin.Default()
@@ -40,102 +58,24 @@ func main() {
 
 	log.Printf("Catalog service starting on port %s", port)
 
-	// Start HTTP server (this will block and keep the service running)
-	if err := r.Run(":" + port); err != nil {
+	// Start HTTP server (this blocks until shutdown)
+	if err := utils.ServeHTTP(ctx, ":"+port, r); err != nil {
 		log.Fatalf("Failed to start HTTP server: %v", err)
 	}
-}
-
-func startKafkaConsumer() {
-	broker := utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")
-	pingTopic := "service-ping"
-	pongTopic := "service-pong"
-	groupID := "catalog-service-group"
-
-	log.Printf("Starting Kafka consumer. Broker: %s", broker)
-
-	// Wait for Kafka to be available with retry logic
-	var connected bool
-	for retries := 0; retries < 30; retries++ {
-		log.Printf("Attempting to connect to Kafka at %s (attempt %d/30)", broker, retries+1)
-
-		// Test connection by creating a temporary consumer
-		conn, err := kafka.DialLeader(context.Background(), "tcp", broker, pingTopic, 0)
-		if err == nil {
-			conn.Close()
-			log.Println("Successfully connected to Kafka")
-			connected = true
-			break
-		}
-
-		log.Printf("Failed to connect to Kafka: %v. Retrying in 5 seconds...", err)
-		time.Sleep(5 * time.Second)
-	}
-
-	if !connected {
-		log.Printf("Failed to connect to Kafka after 30 attempts. Running without Kafka consumer.")
-		return
-	}
-
-	// Create a new Kafka reader
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:     []string{broker},
-		Topic:       pingTopic,
-		GroupID:     groupID,
-		StartOffset: kafka.FirstOffset,
-		MinBytes:    1,
-		MaxBytes:    10e6,
-		MaxWait:     time.Second * 5, // Don't wait too long for messages
-	})
-	defer r.Close()
-
-	// Create new Kafka writer
-	w := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:  []string{broker},
-		Topic:    pongTopic,
-		Balancer: &kafka.LeastBytes{}, // Better load balancing
-	})
-	defer w.Close()
 
-	log.Println("Catalog service Kafka consumer started")
-
-	// Read messages from Kafka
-	for {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		m, err := r.ReadMessage(ctx)
-		cancel()
-
-		if err != nil {
-			if err == context.DeadlineExceeded {
-				// This is normal - no messages received, continue quietly
-				continue
-			}
-			log.Printf("Error reading Kafka message: %v", err)
-			time.Sleep(5 * time.Second) // Wait longer on error
-			continue
-		}
-
-		log.Printf("Received ping: %s", string(m.Value))
+	background.Wait()
+	log.Printf("Catalog service stopped")
+}
 
-		// Check if the ping is specifically for this service
-		if string(m.Key) == "catalog-service" || string(m.Value) == "ping" {
-			// Respond with service status
-			resp := []byte(`{"status":"healthy", "service":"catalog-service", "timestamp":"` + time.Now().Format(time.RFC3339) + `"}`)
-			ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-			err = w.WriteMessages(ctx,
-				kafka.Message{
-					Key:   []byte("catalog-service"),
-					Value: resp,
-					Time:  time.Now(),
-				},
-			)
-			cancel()
+// exportUserData returns the reviews written by the user. The service doesn't
+// store reviews yet, so the list is always empty.
+func exportUserData(ctx context.Context, userID string) (any, error) {
+	return map[string]any{"reviews": []any{}}, nil
+}
 
-			if err != nil {
-				log.Printf("Error writing kafka pong: %v", err)
-			} else {
-				log.Printf("Sent pong response")
-			}
-		}
-	}
+// purgeUserData deletes the reviews written by the user once their account is deleted.
+// Nothing is stored per user yet.
+func purgeUserData(ctx context.Context, userID string) error {
+	log.Printf("No reviews stored for deleted user %s", userID)
+	return nil
 }
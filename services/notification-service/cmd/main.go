@@ -7,6 +7,12 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lucas/notification-service/internal/handlers"
+	"github.com/lucas/notification-service/internal/mailer"
+	"github.com/lucas/shared/consumer"
+	"github.com/lucas/shared/events"
+	"github.com/lucas/shared/health"
+	"github.com/lucas/shared/privacy"
 	"github.com/lucas/shared/utils"
 	"github.com/segmentio/kafka-go"
 )
@@ -14,8 +20,34 @@ import (
 func main() {
 	port := utils.GetEnvOrDefault("PORT", "8087")
 
-	// Start Kafka consumer in a goroutine
-	go startKafkaConsumer()
+	// Consumers stop and drain on SIGTERM
+	ctx, stop := utils.ShutdownContext()
+	defer stop()
+	background := consumer.NewGroup(ctx)
+
+	// Answer the gateway's service pings
+	broker := utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")
+	background.Go(func(ctx context.Context) {
+		health.ServePings(ctx, broker, "notification-service", "notification-service-group")
+	})
+
+	// Take part in personal data exports and account deletions
+	background.Go(func(ctx context.Context) {
+		privacy.ServeDataExports(ctx, broker, "notification-service", exportUserData)
+	})
+	background.Go(func(ctx context.Context) {
+		privacy.ConsumeUserDeletions(ctx, broker, "notification-service", purgeUserData)
+	})
+
+	// Start user events consumer
+	userEventsHandler := handlers.NewUserEventsHandler(
+		mailer.New(),
+		utils.GetEnvOrDefault("VERIFY_EMAIL_URL", "http://localhost:8080/verify-email"),
+		utils.GetEnvOrDefault("RESET_PASSWORD_URL", "http://localhost:8080/reset-password"),
+	)
+	background.Go(func(ctx context.Context) {
+		startUserEventsConsumer(ctx, broker, userEventsHandler)
+	})
 
 	// Create HTTP server for health checks
 	r := gin.Default()
@@ -40,102 +72,42 @@ func main() {
 
 	log.Printf("Notification service starting on port %s", port)
 
-	// Start HTTP server (this will block and keep the service running)
-	if err := r.Run(":" + port); err != nil {
+	// Start HTTP server (this blocks until shutdown)
+	if err := utils.ServeHTTP(ctx, ":"+port, r); err != nil {
 		log.Fatalf("Failed to start HTTP server: %v", err)
 	}
-}
-
-func startKafkaConsumer() {
-	broker := utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")
-	pingTopic := "service-ping"
-	pongTopic := "service-pong"
-	groupID := "notification-service-group"
-
-	log.Printf("Starting Kafka consumer. Broker: %s", broker)
-
-	// Wait for Kafka to be available with retry logic
-	var connected bool
-	for retries := 0; retries < 30; retries++ {
-		log.Printf("Attempting to connect to Kafka at %s (attempt %d/30)", broker, retries+1)
-
-		// Test connection by creating a temporary consumer
-		conn, err := kafka.DialLeader(context.Background(), "tcp", broker, pingTopic, 0)
-		if err == nil {
-			conn.Close()
-			log.Println("Successfully connected to Kafka")
-			connected = true
-			break
-		}
-
-		log.Printf("Failed to connect to Kafka: %v. Retrying in 5 seconds...", err)
-		time.Sleep(5 * time.Second)
-	}
 
-	if !connected {
-		log.Printf("Failed to connect to Kafka after 30 attempts. Running without Kafka consumer.")
-		return
-	}
+	background.Wait()
+	log.Printf("Notification service stopped")
+}
 
-	// Create a new Kafka reader
+func startUserEventsConsumer(ctx context.Context, broker string, handler *handlers.UserEventsHandler) {
 	r := kafka.NewReader(kafka.ReaderConfig{
 		Brokers:     []string{broker},
-		Topic:       pingTopic,
-		GroupID:     groupID,
+		Topic:       events.TopicUserEvents,
+		GroupID:     "notification-service-user-events",
 		StartOffset: kafka.FirstOffset,
 		MinBytes:    1,
 		MaxBytes:    10e6,
-		MaxWait:     time.Second * 5, // Don't wait too long for messages
 	})
-	defer r.Close()
 
-	// Create new Kafka writer
-	w := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:  []string{broker},
-		Topic:    pongTopic,
-		Balancer: &kafka.LeastBytes{}, // Better load balancing
-	})
-	defer w.Close()
-
-	log.Println("Notification service Kafka consumer started")
-
-	// Read messages from Kafka
-	for {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		m, err := r.ReadMessage(ctx)
-		cancel()
-
-		if err != nil {
-			if err == context.DeadlineExceeded {
-				// This is normal - no messages received, continue quietly
-				continue
-			}
-			log.Printf("Error reading Kafka message: %v", err)
-			time.Sleep(5 * time.Second) // Wait longer on error
-			continue
-		}
-
-		log.Printf("Received ping: %s", string(m.Value))
-
-		// Check if the ping is specifically for this service
-		if string(m.Key) == "notification-service" || string(m.Value) == "ping" {
-			// Respond with service status
-			resp := []byte(`{"status":"healthy", "service":"notification-service", "timestamp":"` + time.Now().Format(time.RFC3339) + `"}`)
-			ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-			err = w.WriteMessages(ctx,
-				kafka.Message{
-					Key:   []byte("notification-service"),
-					Value: resp,
-					Time:  time.Now(),
-				},
-			)
-			cancel()
-
-			if err != nil {
-				log.Printf("Error writing kafka pong: %v", err)
-			} else {
-				log.Printf("Sent pong response")
-			}
-		}
-	}
+	// Events of different users are emailed in parallel, a user's in order.
+	// Emails that fail to send are retried from the retry topics, then dead-lettered
+	consumer.New("user events", r, handler.HandleUserEvent).
+		WithConcurrency(8).
+		WithRetryTopics(consumer.DefaultRetryDelays...).
+		Run(ctx)
+}
+
+// exportUserData returns the notifications sent to the user. The service doesn't
+// store notifications yet, so the list is always empty.
+func exportUserData(ctx context.Context, userID string) (any, error) {
+	return map[string]any{"notifications": []any{}}, nil
+}
+
+// purgeUserData deletes the notifications sent to the user once their account is deleted.
+// Nothing is stored per user yet.
+func purgeUserData(ctx context.Context, userID string) error {
+	log.Printf("No notifications stored for deleted user %s", userID)
+	return nil
 }
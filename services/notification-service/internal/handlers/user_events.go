@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/lucas/notification-service/internal/mailer"
+	"github.com/lucas/shared/codec"
+	"github.com/lucas/shared/consumer"
+	"github.com/lucas/shared/events"
+	"github.com/lucas/shared/models"
+	"github.com/segmentio/kafka-go"
+)
+
+// UserEventsHandler turns user-service events into emails.
+type UserEventsHandler struct {
+	mailer           mailer.Mailer
+	verifyEmailURL   string
+	resetPasswordURL string
+}
+
+func NewUserEventsHandler(mailer mailer.Mailer, verifyEmailURL, resetPasswordURL string) *UserEventsHandler {
+	return &UserEventsHandler{
+		mailer:           mailer,
+		verifyEmailURL:   verifyEmailURL,
+		resetPasswordURL: resetPasswordURL,
+	}
+}
+
+// HandleUserEvent sends the email an event calls for. Failed sends are
+// returned to be retried; events that can't be sent are marked permanent.
+func (h *UserEventsHandler) HandleUserEvent(ctx context.Context, message kafka.Message) ([]byte, error) {
+	event, err := events.Schemas.Decode(codec.ContentTypeOf(message), message.Value)
+	if err != nil {
+		return nil, consumer.Permanent(fmt.Errorf("failed to decode user event: %w", err))
+	}
+
+	switch event.Type {
+	case models.EventUserRegistered:
+		return nil, h.sendTokenLink(event, h.verifyEmailURL, "Verify your email address",
+			"Welcome %s!\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in 24 hours.")
+	case models.EventEmailChangeRequested:
+		return nil, h.sendTokenLink(event, h.verifyEmailURL, "Confirm your new email address",
+			"Hi %s,\n\nWe received a request to change the email address of your account. Confirm it by opening the link below:\n\n%s\n\nIf you didn't request this change you can ignore this email.")
+	case models.EventPasswordResetRequested:
+		return nil, h.sendTokenLink(event, h.resetPasswordURL, "Reset your password",
+			"Hi %s,\n\nWe received a request to reset your password. Choose a new one by opening the link below:\n\n%s\n\nThe link expires in 1 hour. If you didn't request a reset you can ignore this email.")
+	default:
+		// Other user events don't trigger notifications
+		return nil, nil
+	}
+}
+
+// sendTokenLink emails the event's token as a link to baseURL.
+func (h *UserEventsHandler) sendTokenLink(event *events.Envelope, baseURL, subject, template string) error {
+	data, err := events.DataAs[models.TokenEventData](event)
+	if err != nil || data.Email == "" || data.Token == "" {
+		return consumer.Permanent(fmt.Errorf("invalid %s event %s for user %s", event.Type, event.ID, event.Subject))
+	}
+
+	link := baseURL + "?token=" + url.QueryEscape(data.Token)
+	body := fmt.Sprintf(template, data.Name, link)
+
+	if err := h.mailer.Send(data.Email, subject, body); err != nil {
+		return fmt.Errorf("failed to send %s email for user %s: %w", event.Type, event.Subject, err)
+	}
+
+	log.Printf("Sent %s email for user %s", event.Type, event.Subject)
+	return nil
+}
@@ -0,0 +1,71 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/lucas/shared/utils"
+)
+
+// Mailer delivers plain text emails.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// New returns an SMTP mailer when SMTP_HOST is set. Without it emails are
+// only logged, which is enough for local development.
+func New() Mailer {
+	host := utils.GetEnvOrDefault("SMTP_HOST", "")
+	if host == "" {
+		log.Printf("SMTP_HOST not set, emails will be logged instead of sent")
+		return &logMailer{}
+	}
+
+	return &smtpMailer{
+		addr:     net.JoinHostPort(host, utils.GetEnvOrDefault("SMTP_PORT", "587")),
+		host:     host,
+		username: utils.GetEnvOrDefault("SMTP_USERNAME", ""),
+		password: utils.GetEnvOrDefault("SMTP_PASSWORD", ""),
+		from:     utils.GetEnvOrDefault("SMTP_FROM", "no-reply@microcommerce.local"),
+	}
+}
+
+type smtpMailer struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + m.from,
+		"To: " + to,
+		"Subject: " + subject,
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=UTF-8",
+		"",
+		body,
+	}, "\r\n")
+
+	if err := smtp.SendMail(m.addr, auth, m.from, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", to, err)
+	}
+	return nil
+}
+
+type logMailer struct{}
+
+func (m *logMailer) Send(to, subject, body string) error {
+	log.Printf("Email to %s - Subject: %s\n%s", to, subject, body)
+	return nil
+}
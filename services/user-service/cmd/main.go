@@ -2,21 +2,35 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"expvar"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lucas/shared/consumer"
 	"github.com/lucas/shared/database"
+	"github.com/lucas/shared/dedupe"
+	sharedevents "github.com/lucas/shared/events"
+	"github.com/lucas/shared/health"
+	"github.com/lucas/shared/outbox"
+	"github.com/lucas/shared/rpc"
 	"github.com/lucas/shared/utils"
+	"github.com/lucas/user-service/internal/events"
+	"github.com/lucas/user-service/internal/export"
 	"github.com/lucas/user-service/internal/handlers"
+	"github.com/lucas/user-service/internal/oidc"
 	"github.com/lucas/user-service/internal/repository"
 	"github.com/lucas/user-service/internal/services"
-	"github.com/segmentio/kafka-go"
+	"github.com/lucas/user-service/internal/tokens"
 )
 
 func main() {
+	// Consumers and background loops stop and drain on SIGTERM
+	ctx, stop := utils.ShutdownContext()
+	defer stop()
+	background := consumer.NewGroup(ctx)
+
 	// 1. Initialize database
 	if err := initDatabase(); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
@@ -31,34 +45,67 @@ func main() {
 
 	// 3. Set up dependencies
 	userRepo := repository.NewUserRepository(database.GetDB())
-	userService := services.NewUserService(userRepo)
+	keysDir := utils.GetEnvOrDefault("JWT_KEYS_DIR", "")
+	// An ephemeral key differs per replica and dies with the pod, taking every
+	// issued token with it, so deployments require a key directory
+	if keysDir == "" && utils.GetEnvBoolOrDefault("REQUIRE_JWT_KEYS", false) {
+		log.Fatalf("JWT_KEYS_DIR must be set when REQUIRE_JWT_KEYS is")
+	}
+	keyManager, err := tokens.NewKeyManager(keysDir, utils.GetEnvOrDefault("JWT_ACTIVE_KID", ""))
+	if err != nil {
+		log.Fatalf("Failed to load token signing keys: %v", err)
+	}
+	background.Go(func(ctx context.Context) { keyManager.Watch(ctx, time.Minute) })
+
+	tokenIssuer := tokens.NewTokenIssuer(
+		keyManager,
+		utils.GetEnvOrDefault("JWT_ISSUER", "microcommerce-user-service"),
+		utils.GetEnvDurationOrDefault("ACCESS_TOKEN_TTL", 15*time.Minute),
+	)
 
 	// 4. Set up Kafka
-	kafkaWriter := &kafka.Writer{
-		Addr:     kafka.TCP(utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")),
-		Topic:    "user-responses",
-		Balancer: &kafka.LeastBytes{},
+	// Refuse to publish with event schemas that would break consumers
+	if err := sharedevents.Schemas.Check(); err != nil {
+		log.Fatalf("Incompatible event schemas: %v", err)
 	}
-	defer kafkaWriter.Close()
 
-	// Kafka writer for health check responses
-	healthWriter := &kafka.Writer{
-		Addr:     kafka.TCP(utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")),
-		Topic:    "service-pong",
-		Balancer: &kafka.LeastBytes{},
-	}
-	defer healthWriter.Close()
+	broker := utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")
 
-	log.Printf("Kakfa writers running!")
+	// Events are written to the outbox table and relayed to Kafka from there
+	background.Go(outbox.NewRelay(database.GetDB(), broker).Run)
+	eventsPublisher := events.NewPublisher(database.GetDB(), sharedevents.TopicUserEvents, "user-service")
 
-	kafkaHandler := handlers.NewKafkaHandler(userService, kafkaWriter)
+	exports := export.NewCollector(broker, utils.GetInstanceID())
+	background.Go(exports.Run)
+
+	userService := services.NewUserService(userRepo, tokenIssuer, eventsPublisher, services.Config{
+		SessionTTL:           utils.GetEnvDurationOrDefault("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		RequireVerifiedEmail: utils.GetEnvBoolOrDefault("REQUIRE_EMAIL_VERIFICATION", false),
+		MFAIssuer:            utils.GetEnvOrDefault("MFA_ISSUER", "MicroCommerce"),
+		IdentityProviders:    oidc.LoadProviders(),
+		Exports:              exports,
+		ExportServices:       utils.GetEnvListOrDefault("EXPORT_SERVICES", []string{"catalog-service", "transaction-service", "notification-service"}),
+	})
+
+	// Requests are dropped once past their deadline, so replies only need
+	// remembering for a few minutes
+	rpcDedupe := dedupe.NewRedisStore(database.GetRedisClient(), "rpc:user-service", 10*time.Minute)
+	rpcServer := rpc.NewServer(broker, "user-service", 16).WithDedupe(rpcDedupe)
+	handlers.NewKafkaHandler(userService).Register(rpcServer)
 
 	// 5. Start Kafka consumers
-	go startUserRequestsConsumer(kafkaHandler)
-	go startHealthCheckConsumer(healthWriter)
+	background.Go(rpcServer.Serve)
+	background.Go(func(ctx context.Context) {
+		health.ServePings(ctx, broker, "user-service", "user-service-health-group")
+	})
 
-	// 6. Start HTTP server for health checks
-	startHTTPServer()
+	// 6. Start HTTP server for health checks and the JWKS document
+	startHTTPServer(ctx, keyManager)
+
+	background.Wait()
+	// Consumers are stopped, so no request starts more background work
+	userService.Wait()
+	log.Printf("User service stopped")
 }
 
 func initDatabase() error {
@@ -80,35 +127,16 @@ func initRedis() error {
 	return database.ConnectRedis(config)
 }
 
-func startUserRequestsConsumer(handler *handlers.KafkaHandler) {
-	broker := utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")
-
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:     []string{broker},
-		Topic:       "user-requests",
-		GroupID:     "user-service-group",
-		StartOffset: kafka.FirstOffset, // Changed to FirstOffset for consistency
-	})
-	defer reader.Close()
-
-	log.Println("User requests consumer started")
-
-	for {
-		message, err := reader.ReadMessage(context.Background())
-		if err != nil {
-			log.Printf("Error reading message: %v", err)
-			continue
-		}
-
-		log.Printf("Processing message with key: %s", string(message.Key))
-		handler.HandleUserMessage(message)
-	}
-}
-
-func startHTTPServer() {
+func startHTTPServer(ctx context.Context, keyManager *tokens.KeyManager) {
 	port := utils.GetEnvOrDefault("PORT", "8083")
 	r := gin.Default()
 
+	// Public keys the gateway and other services use to verify access tokens
+	r.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, keyManager.JWKS())
+	})
+
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "healthy",
@@ -116,54 +144,11 @@ func startHTTPServer() {
 		})
 	})
 
+	// Request counts, latencies and error codes of the RPC server
+	r.GET("/debug/vars", gin.WrapH(expvar.Handler()))
+
 	log.Printf("User service starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
+	if err := utils.ServeHTTP(ctx, ":"+port, r); err != nil {
 		log.Fatalf("Failed to start HTTP server: %v", err)
 	}
 }
-
-func startHealthCheckConsumer(writer *kafka.Writer) {
-	broker := utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")
-
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:     []string{broker},
-		Topic:       "service-ping",
-		GroupID:     "user-service-health-group",
-		StartOffset: kafka.LastOffset,
-	})
-	defer reader.Close()
-
-	log.Println("Health check consumer started")
-
-	for {
-		message, err := reader.ReadMessage(context.Background())
-		if err != nil {
-			log.Printf("Error reading health check message: %v", err)
-			continue
-		}
-
-		// Respond to health check pings
-		if string(message.Key) == "user-service" || string(message.Value) == "ping" {
-			response := map[string]interface{}{
-				"status":    "healthy",
-				"service":   "user-service",
-				"timestamp": time.Now().Format(time.RFC3339),
-			}
-
-			responseBytes, _ := json.Marshal(response)
-
-			err = writer.WriteMessages(context.Background(),
-				kafka.Message{
-					Key:   []byte("user-service"),
-					Value: responseBytes,
-				},
-			)
-
-			if err != nil {
-				log.Printf("Failed to send health check response: %v", err)
-			} else {
-				log.Printf("Sent health check response")
-			}
-		}
-	}
-}
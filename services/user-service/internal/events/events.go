@@ -0,0 +1,69 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/lucas/shared/codec"
+	sharedevents "github.com/lucas/shared/events"
+	"github.com/lucas/shared/outbox"
+)
+
+// Publisher emits user lifecycle events for other services (e.g. notification-service).
+// Events go through the outbox, which the relay started in main sends to Kafka.
+type Publisher struct {
+	db          *sql.DB
+	topic       string
+	source      string
+	contentType string
+}
+
+// NewPublisher returns a publisher stamping source as the producer of its
+// events, encoded as set by WIRE_FORMAT.
+func NewPublisher(db *sql.DB, topic, source string) *Publisher {
+	return &Publisher{db: db, topic: topic, source: source, contentType: codec.Preferred()}
+}
+
+// Event builds the outbox message of an event about userID, keyed by user ID
+// so a user's events stay in order. data must be the payload registered for
+// eventType in events.Schemas. Enqueue it in the transaction of the change
+// the event describes.
+func (p *Publisher) Event(eventType string, userID string, data any) (outbox.Message, error) {
+	event, err := sharedevents.Schemas.NewEnvelope(context.Background(), eventType, p.source, userID, data)
+	if err != nil {
+		return outbox.Message{}, err
+	}
+
+	eventBytes, contentType, err := codec.Marshal(p.contentType, event)
+	if err != nil {
+		return outbox.Message{}, fmt.Errorf("failed to marshal %s event: %w", eventType, err)
+	}
+
+	return outbox.Message{
+		Topic:   p.topic,
+		Key:     userID,
+		Value:   eventBytes,
+		Headers: map[string]string{codec.HeaderContentType: contentType},
+	}, nil
+}
+
+// Publish queues an event that doesn't go with a database change.
+func (p *Publisher) Publish(eventType string, userID string, data any) error {
+	message, err := p.Event(eventType, userID, data)
+	if err != nil {
+		return err
+	}
+	if err := p.Enqueue(message); err != nil {
+		return fmt.Errorf("failed to publish %s event: %w", eventType, err)
+	}
+
+	log.Printf("Queued %s event for user %s", eventType, userID)
+	return nil
+}
+
+// Enqueue queues events built with Event on their own.
+func (p *Publisher) Enqueue(messages ...outbox.Message) error {
+	return outbox.Enqueue(context.Background(), p.db, messages...)
+}
@@ -0,0 +1,141 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucas/shared/consumer"
+	"github.com/lucas/shared/models"
+	"github.com/segmentio/kafka-go"
+)
+
+// Collector fans data export requests out to the other services and gathers
+// their fragments. Every user-service instance reads all fragments through its
+// own consumer group and keeps the ones addressed to it.
+type Collector struct {
+	instanceID string
+	writer     *kafka.Writer
+	reader     *kafka.Reader
+
+	mu      sync.Mutex
+	pending map[string]chan models.DataExportFragment
+}
+
+func NewCollector(broker, instanceID string) *Collector {
+	return &Collector{
+		instanceID: instanceID,
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(broker),
+			Topic:    models.TopicDataExportRequests,
+			Balancer: &kafka.LeastBytes{},
+		},
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     []string{broker},
+			Topic:       models.TopicDataExportFragments,
+			GroupID:     "user-service-exports-" + instanceID,
+			StartOffset: kafka.LastOffset, // Fragments sent before startup have no one waiting for them
+		}),
+		pending: make(map[string]chan models.DataExportFragment),
+	}
+}
+
+// Run consumes fragments until ctx is cancelled. It must be started once per collector.
+func (c *Collector) Run(ctx context.Context) {
+	defer c.writer.Close()
+
+	consumer.New("data export fragments", c.reader, c.handleFragment).Run(ctx)
+}
+
+// handleFragment hands a fragment addressed to this instance to the export waiting for it.
+func (c *Collector) handleFragment(_ context.Context, message kafka.Message) ([]byte, error) {
+	var fragment models.DataExportFragment
+	if err := json.Unmarshal(message.Value, &fragment); err != nil {
+		log.Printf("Failed to unmarshal data export fragment: %v", err)
+		return nil, nil
+	}
+	if fragment.ReplyTo != c.instanceID {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	ch, ok := c.pending[fragment.ExportID]
+	c.mu.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	select {
+	case ch <- fragment:
+	default:
+		log.Printf("Discarding extra fragment from %s for export %s", fragment.Service, fragment.ExportID)
+	}
+	return nil, nil
+}
+
+// Collect asks every service in services for its data about userID and
+// waits until all answered or timeout elapsed. It returns the fragments by
+// service and the services that didn't answer in time or failed.
+func (c *Collector) Collect(ctx context.Context, userID string, services []string, timeout time.Duration) (map[string]any, []string, error) {
+	exportID := uuid.New().String()
+	ch := make(chan models.DataExportFragment, len(services))
+
+	c.mu.Lock()
+	c.pending[exportID] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, exportID)
+		c.mu.Unlock()
+	}()
+
+	request, _ := json.Marshal(models.DataExportRequest{
+		ExportID:    exportID,
+		UserID:      userID,
+		ReplyTo:     c.instanceID,
+		RequestedAt: time.Now(),
+	})
+	if err := c.writer.WriteMessages(ctx, kafka.Message{Key: []byte(userID), Value: request}); err != nil {
+		return nil, nil, fmt.Errorf("failed to publish data export request: %w", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	fragments := make(map[string]any, len(services))
+	failed := make(map[string]bool)
+	for len(fragments)+len(failed) < len(services) {
+		select {
+		case fragment := <-ch:
+			if !slices.Contains(services, fragment.Service) {
+				continue
+			}
+			if fragment.Error != "" {
+				failed[fragment.Service] = true
+				continue
+			}
+			fragments[fragment.Service] = fragment.Data
+		case <-timer.C:
+			return fragments, missing(services, fragments), nil
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	return fragments, missing(services, fragments), nil
+}
+
+func missing(services []string, fragments map[string]any) []string {
+	var names []string
+	for _, service := range services {
+		if _, ok := fragments[service]; !ok {
+			names = append(names, service)
+		}
+	}
+	return names
+}
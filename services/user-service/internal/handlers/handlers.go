@@ -2,63 +2,65 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
 	"log"
-	"net/http"
 
 	"github.com/lucas/shared/models"
+	"github.com/lucas/shared/rpc"
 	usermodels "github.com/lucas/user-service/internal/models"
 	"github.com/lucas/user-service/internal/services"
-	"github.com/segmentio/kafka-go"
 )
 
 type KafkaHandler struct {
 	userService *services.UserService
-	writer      *kafka.Writer
 }
 
-func NewKafkaHandler(userService *services.UserService, writer *kafka.Writer) *KafkaHandler {
+func NewKafkaHandler(userService *services.UserService) *KafkaHandler {
 	return &KafkaHandler{
 		userService: userService,
-		writer:      writer,
 	}
 }
 
-func (h *KafkaHandler) HandleUserMessage(message kafka.Message) {
-	log.Printf("Received Kafka message - Key: %s, Value: %s", string(message.Key), string(message.Value))
-
-	var userMsg models.UserServiceMessage
-	if err := json.Unmarshal(message.Value, &userMsg); err != nil {
-		log.Printf("Failed to unmarshal message: %v", err)
-		return
-	}
-
-	log.Printf("Parsed message - CorrelationID: %s, Action: %s", userMsg.CorrelationID, userMsg.Action)
-
-	switch userMsg.Action {
-	case "register":
-		h.handleRegister(userMsg)
-	case "login":
-		h.handleLogin(userMsg)
-	case "get_profile":
-		h.handleGetProfile(userMsg)
-	default:
-		log.Printf("Unknown action: %s", userMsg.Action)
-	}
+// Register installs a handler on server for every user-service action.
+// Actions returning tokens, keys, MFA secrets or personal data (users,
+// sessions, audit entries) are registered as secret so their replies are
+// only recorded until sent.
+func (h *KafkaHandler) Register(server *rpc.Server) {
+	rpc.HandleSecret(server, "register", h.handleRegister)
+	rpc.HandleSecret(server, "login", h.handleLogin)
+	rpc.HandleSecret(server, "refresh", h.handleRefresh)
+	rpc.Handle(server, "logout", h.handleLogout)
+	rpc.HandleSecret(server, "list_sessions", h.handleListSessions)
+	rpc.Handle(server, "revoke_session", h.handleRevokeSession)
+	rpc.HandleSecret(server, "create_api_key", h.handleCreateAPIKey)
+	rpc.Handle(server, "list_api_keys", h.handleListAPIKeys)
+	rpc.Handle(server, "revoke_api_key", h.handleRevokeAPIKey)
+	rpc.HandleSecret(server, "authenticate_api_key", h.handleAuthenticateAPIKey)
+	rpc.HandleSecret(server, "get_profile", h.handleGetProfile)
+	rpc.HandleSecret(server, "update_profile", h.handleUpdateProfile)
+	rpc.Handle(server, "change_password", h.handleChangePassword)
+	rpc.HandleSecret(server, "verify_email", h.handleVerifyEmail)
+	rpc.Handle(server, "resend_verification", h.handleResendVerification)
+	rpc.Handle(server, "oauth_start", h.handleOAuthStart)
+	rpc.Handle(server, "link_identity", h.handleLinkIdentity)
+	rpc.HandleSecret(server, "oauth_callback", h.handleOAuthCallback)
+	rpc.HandleSecret(server, "verify_mfa", h.handleVerifyMFA)
+	rpc.HandleSecret(server, "enroll_mfa", h.handleEnrollMFA)
+	rpc.HandleSecret(server, "confirm_mfa", h.handleConfirmMFA)
+	rpc.Handle(server, "unlock_account", h.handleUnlockAccount)
+	rpc.HandleSecret(server, "search_users", h.handleSearchUsers)
+	rpc.HandleSecret(server, "disable_user", h.handleDisableUser)
+	rpc.HandleSecret(server, "enable_user", h.handleEnableUser)
+	rpc.Handle(server, "set_user_roles", h.handleSetUserRoles)
+	rpc.Handle(server, "force_password_reset", h.handleForcePasswordReset)
+	rpc.Handle(server, "force_logout", h.handleForceLogout)
+	rpc.HandleSecret(server, "list_audit", h.handleListAudit)
+	rpc.Handle(server, "forgot_password", h.handleForgotPassword)
+	rpc.Handle(server, "reset_password", h.handleResetPassword)
+	rpc.HandleSecret(server, "export_data", h.handleExportData)
+	rpc.Handle(server, "delete_account", h.handleDeleteAccount)
 }
 
-func (h *KafkaHandler) handleRegister(userMsg models.UserServiceMessage) {
-
-	log.Printf("Received regsiter message: %s", userMsg)
-
-	// Parse register request
-	reqBytes, _ := json.Marshal(userMsg.Data)
-	var registerReq models.RegisterRequest
-	if err := json.Unmarshal(reqBytes, &registerReq); err != nil {
-		h.sendErrorResponse(userMsg.CorrelationID, http.StatusBadRequest, "Invalid request format")
-		return
-	}
-
+func (h *KafkaHandler) handleRegister(ctx context.Context, registerReq models.RegisterRequest) (any, error) {
 	// Convert to internal model
 	createUserReq := &usermodels.RegisterUserRequest{
 		Email:    registerReq.Email,
@@ -66,115 +68,458 @@ func (h *KafkaHandler) handleRegister(userMsg models.UserServiceMessage) {
 		Password: registerReq.Password,
 	}
 
-	log.Printf("Trying to regsiter user: %s", createUserReq)
+	log.Printf("Trying to register user: %s", createUserReq.Email)
 
 	// Register user
 	user, err := h.userService.RegisterUser(createUserReq)
 	if err != nil {
-		statusCode := http.StatusInternalServerError
-		if err.Error() == "email already registered" || err.Error() == "invalid email format" {
-			statusCode = http.StatusBadRequest
-		}
-		h.sendErrorResponse(userMsg.CorrelationID, statusCode, err.Error())
-		return
+		return nil, err
 	}
 
-	// Send success response
-	h.sendSuccessResponse(userMsg.CorrelationID, http.StatusCreated, map[string]any{
+	log.Printf("User registered successfully: %+v", user)
+
+	return map[string]any{
 		"user":    user,
 		"message": "User registered successfully",
+	}, nil
+}
+
+func (h *KafkaHandler) handleLogin(ctx context.Context, registerReq models.LoginRequest) (any, error) {
+	// Convert to internal model
+	loginUserReq := &usermodels.LoginUserRequest{
+		Email:     registerReq.Email,
+		Password:  registerReq.Password,
+		IPAddress: rpc.Metadata(ctx, rpc.MetadataClientIP),
+		UserAgent: rpc.Metadata(ctx, rpc.MetadataUserAgent),
+	}
+
+	log.Printf("Trying to login user: %s", loginUserReq.Email)
+
+	// Login user
+	result, err := h.userService.LoginUser(loginUserReq)
+	if err != nil {
+		return nil, err
+	}
+
+	return loginResponse(result), nil
+}
+
+func (h *KafkaHandler) handleOAuthStart(ctx context.Context, startReq models.OAuthStartRequest) (any, error) {
+	authorizationURL, err := h.userService.StartOAuthLogin(startReq.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"authorization_url": authorizationURL,
+	}, nil
+}
+
+func (h *KafkaHandler) handleLinkIdentity(ctx context.Context, linkReq models.LinkIdentityRequest) (any, error) {
+	authorizationURL, err := h.userService.StartIdentityLink(linkReq.UserID, linkReq.Provider, linkReq.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"authorization_url": authorizationURL,
+	}, nil
+}
+
+func (h *KafkaHandler) handleOAuthCallback(ctx context.Context, callbackReq models.OAuthCallbackRequest) (any, error) {
+	result, err := h.userService.CompleteOAuthLogin(&usermodels.OAuthCallbackRequest{
+		Provider:  callbackReq.Provider,
+		Code:      callbackReq.Code,
+		State:     callbackReq.State,
+		IPAddress: rpc.Metadata(ctx, rpc.MetadataClientIP),
+		UserAgent: rpc.Metadata(ctx, rpc.MetadataUserAgent),
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	log.Printf("User registered successfully: %+v", user)
+	return loginResponse(result), nil
 }
 
-func (h *KafkaHandler) handleLogin(userMsg models.UserServiceMessage) {
+func (h *KafkaHandler) handleVerifyMFA(ctx context.Context, verifyReq models.VerifyMFARequest) (any, error) {
+	result, err := h.userService.VerifyMFA(&usermodels.VerifyMFARequest{
+		MFAToken:  verifyReq.MFAToken,
+		Code:      verifyReq.Code,
+		IPAddress: rpc.Metadata(ctx, rpc.MetadataClientIP),
+		UserAgent: rpc.Metadata(ctx, rpc.MetadataUserAgent),
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	log.Printf("Received login message: %s", userMsg)
+	return loginResponse(result), nil
+}
 
-	// Parse register request
-	reqBytes, _ := json.Marshal(userMsg.Data)
-	var registerReq models.LoginRequest
-	if err := json.Unmarshal(reqBytes, &registerReq); err != nil {
-		h.sendErrorResponse(userMsg.CorrelationID, http.StatusBadRequest, "Invalid request format")
-		return
+// loginResponse answers a login with either the new session or the MFA
+// challenge the client has to complete first.
+func loginResponse(result *usermodels.LoginResult) any {
+	if result.MFAChallenge != nil {
+		return map[string]any{
+			"mfa_required": true,
+			"mfa_token":    result.MFAChallenge.Token,
+			"expires_in":   result.MFAChallenge.ExpiresIn,
+			"message":      "Enter the code from your authenticator app",
+		}
 	}
 
+	log.Printf("User logged and session created successfully: %+v", result.Session)
+
+	return map[string]any{
+		"session":       result.Session,
+		"token":         result.Tokens.AccessToken,
+		"token_type":    result.Tokens.TokenType,
+		"expires_in":    result.Tokens.ExpiresIn,
+		"refresh_token": result.Tokens.RefreshToken,
+		"message":       "User logged in successfully",
+	}
+}
+
+func (h *KafkaHandler) handleRefresh(ctx context.Context, refreshReq models.RefreshRequest) (any, error) {
+	if refreshReq.RefreshToken == "" {
+		return nil, usermodels.ErrInvalidRequest
+	}
+
+	// Rotate refresh token
+	session, tokenPair, err := h.userService.RefreshSession(refreshReq.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Session refreshed successfully: %s", session.ID)
+
+	return map[string]any{
+		"session":       session,
+		"token":         tokenPair.AccessToken,
+		"token_type":    tokenPair.TokenType,
+		"expires_in":    tokenPair.ExpiresIn,
+		"refresh_token": tokenPair.RefreshToken,
+	}, nil
+}
+
+func (h *KafkaHandler) handleLogout(ctx context.Context, logoutReq models.LogoutRequest) (any, error) {
 	// Convert to internal model
-	loginUserReq := &usermodels.LoginUserRequest{
-		Email:    registerReq.Email,
-		Password: registerReq.Password,
+	logoutUserReq := &usermodels.LogoutUserRequest{
+		UserID:         logoutReq.UserID,
+		SessionID:      logoutReq.SessionID,
+		TokenID:        logoutReq.TokenID,
+		TokenExpiresAt: logoutReq.TokenExpiresAt,
+		AllDevices:     logoutReq.AllDevices,
+	}
+
+	// Logout user
+	if err := h.userService.LogoutUser(logoutUserReq); err != nil {
+		return nil, err
 	}
 
-	log.Printf("Trying to login user: %s", loginUserReq)
+	message := "Logged out successfully"
+	if logoutReq.AllDevices {
+		message = "Logged out of all devices successfully"
+	}
 
-	// Login user
-	session, token, err := h.userService.LoginUser(loginUserReq)
+	log.Printf("User %s logged out (all devices: %t)", logoutReq.UserID, logoutReq.AllDevices)
+
+	return map[string]any{
+		"message": message,
+	}, nil
+}
+
+func (h *KafkaHandler) handleListSessions(ctx context.Context, listReq models.ListSessionsRequest) (any, error) {
+	sessions, err := h.userService.ListSessions(listReq.UserID)
 	if err != nil {
-		statusCode := http.StatusInternalServerError
-		if err.Error() == "user not found" || err.Error() == "invalid credentials" || err.Error() == "invalid email format" {
-			statusCode = http.StatusBadRequest
-		}
-		h.sendErrorResponse(userMsg.CorrelationID, statusCode, err.Error())
-		return
+		return nil, err
+	}
+
+	return map[string]any{
+		"sessions":           sessions,
+		"current_session_id": listReq.SessionID,
+	}, nil
+}
+
+func (h *KafkaHandler) handleRevokeSession(ctx context.Context, revokeReq models.RevokeSessionRequest) (any, error) {
+	if err := h.userService.RevokeSession(revokeReq.UserID, revokeReq.SessionID); err != nil {
+		return nil, err
 	}
 
-	// Send success response
-	h.sendSuccessResponse(userMsg.CorrelationID, http.StatusCreated, map[string]any{
-		"session": session,
-		"token":   token,
-		"message": "User logged in successfully",
+	return map[string]any{
+		"message": "Session revoked successfully",
+	}, nil
+}
+
+func (h *KafkaHandler) handleCreateAPIKey(ctx context.Context, createReq models.CreateAPIKeyRequest) (any, error) {
+	apiKey, key, err := h.userService.CreateAPIKey(&usermodels.CreateAPIKeyRequest{
+		UserID:    createReq.UserID,
+		Name:      createReq.Name,
+		Scopes:    createReq.Scopes,
+		ExpiresAt: createReq.ExpiresAt,
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	log.Printf("User logged and session created successfully: %+v", session)
+	return map[string]any{
+		"api_key": apiKey,
+		"key":     key,
+		"message": "API key created, store it now as it won't be shown again",
+	}, nil
 }
 
-func (h *KafkaHandler) handleLogout(message models.UserServiceMessage) {
-	log.Printf("Method not implemented")
+func (h *KafkaHandler) handleListAPIKeys(ctx context.Context, listReq models.ListAPIKeysRequest) (any, error) {
+	keys, err := h.userService.ListAPIKeys(listReq.UserID)
+	if err != nil {
+		return nil, err
+	}
 
+	return map[string]any{
+		"api_keys": keys,
+	}, nil
 }
 
-func (h *KafkaHandler) handleGetProfile(message models.UserServiceMessage) {
-	log.Printf("Method not implemented")
+func (h *KafkaHandler) handleRevokeAPIKey(ctx context.Context, revokeReq models.RevokeAPIKeyRequest) (any, error) {
+	if err := h.userService.RevokeAPIKey(revokeReq.UserID, revokeReq.KeyID); err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"message": "API key revoked successfully",
+	}, nil
 }
 
-func (h *KafkaHandler) sendSuccessResponse(correlationID string, statusCode int, data any) {
-	log.Printf("Sending success response")
-	response := models.UserServiceResponse{
-		CorrelationID: correlationID,
-		StatusCode:    statusCode,
-		Data:          data,
+func (h *KafkaHandler) handleAuthenticateAPIKey(ctx context.Context, authReq models.AuthenticateAPIKeyRequest) (any, error) {
+	identity, err := h.userService.AuthenticateAPIKey(authReq.Key)
+	if err != nil {
+		return nil, err
 	}
-	h.sendResponse(response)
+
+	return identity, nil
 }
 
-func (h *KafkaHandler) sendErrorResponse(correlationID string, statusCode int, errorMsg string) {
-	log.Printf("Sending error response")
-	response := models.UserServiceResponse{
-		CorrelationID: correlationID,
-		StatusCode:    statusCode,
-		Data:          map[string]string{"error": errorMsg},
-		Error:         errorMsg,
+func (h *KafkaHandler) handleGetProfile(ctx context.Context, profileReq models.GetProfileRequest) (any, error) {
+	user, err := h.userService.GetProfile(profileReq.UserID)
+	if err != nil {
+		return nil, err
 	}
-	h.sendResponse(response)
+
+	return map[string]any{
+		"user": user,
+	}, nil
 }
 
-func (h *KafkaHandler) sendResponse(response models.UserServiceResponse) {
-	responseBytes, _ := json.Marshal(response)
+func (h *KafkaHandler) handleUpdateProfile(ctx context.Context, updateReq models.UpdateProfileRequest) (any, error) {
+	// Convert to internal model
+	updateProfileReq := &usermodels.UpdateProfileRequest{
+		UserID: updateReq.UserID,
+		Name:   updateReq.Name,
+		Email:  updateReq.Email,
+	}
 
-	log.Printf("Sending response to user-responses topic - CorrelationID: %s, StatusCode: %d", response.CorrelationID, response.StatusCode)
-	log.Printf("Response payload: %s", string(responseBytes))
+	user, err := h.userService.UpdateProfile(updateProfileReq)
+	if err != nil {
+		return nil, err
+	}
+
+	message := "Profile updated successfully"
+	if user.PendingEmail != "" && updateReq.Email != nil && *updateReq.Email == user.PendingEmail {
+		message = "Profile updated, check your new email address to confirm the change"
+	}
+
+	return map[string]any{
+		"user":    user,
+		"message": message,
+	}, nil
+}
+
+func (h *KafkaHandler) handleChangePassword(ctx context.Context, passwordReq models.ChangePasswordRequest) (any, error) {
+	// Convert to internal model
+	changePasswordReq := &usermodels.ChangePasswordRequest{
+		UserID:          passwordReq.UserID,
+		CurrentPassword: passwordReq.CurrentPassword,
+		NewPassword:     passwordReq.NewPassword,
+	}
+
+	if err := h.userService.ChangePassword(changePasswordReq); err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"message": "Password changed successfully, please log in again",
+	}, nil
+}
+
+func (h *KafkaHandler) handleVerifyEmail(ctx context.Context, verifyReq models.VerifyEmailRequest) (any, error) {
+	user, err := h.userService.VerifyEmail(verifyReq.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"user":    user,
+		"message": "Email verified successfully",
+	}, nil
+}
+
+func (h *KafkaHandler) handleResendVerification(ctx context.Context, resendReq models.ResendVerificationRequest) (any, error) {
+	if err := h.userService.ResendVerification(resendReq.Email); err != nil {
+		return nil, err
+	}
+
+	// Same answer whether or not the email is registered
+	return map[string]any{
+		"message": "If the account exists and is not verified yet, a new verification email has been sent",
+	}, nil
+}
+
+func (h *KafkaHandler) handleForgotPassword(ctx context.Context, forgotReq models.ForgotPasswordRequest) (any, error) {
+	if err := h.userService.ForgotPassword(forgotReq.Email, rpc.Metadata(ctx, rpc.MetadataClientIP)); err != nil {
+		return nil, err
+	}
+
+	// Same answer whether or not the email is registered
+	return map[string]any{
+		"message": "If an account exists for this email, a password reset link has been sent",
+	}, nil
+}
+
+func (h *KafkaHandler) handleResetPassword(ctx context.Context, resetReq models.ResetPasswordRequest) (any, error) {
+	if err := h.userService.ResetPassword(resetReq.Token, resetReq.NewPassword); err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"message": "Password reset successfully, please log in again",
+	}, nil
+}
+
+func (h *KafkaHandler) handleEnrollMFA(ctx context.Context, enrollReq models.EnrollMFARequest) (any, error) {
+	enrollment, err := h.userService.EnrollMFA(enrollReq.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"secret":      enrollment.Secret,
+		"otpauth_uri": enrollment.URI,
+		"message":     "Add the account to your authenticator app, then confirm with a code",
+	}, nil
+}
+
+func (h *KafkaHandler) handleConfirmMFA(ctx context.Context, confirmReq models.ConfirmMFARequest) (any, error) {
+	recoveryCodes, err := h.userService.ConfirmMFA(confirmReq.UserID, confirmReq.Code)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"recovery_codes": recoveryCodes,
+		"message":        "Two-factor authentication enabled, store the recovery codes somewhere safe",
+	}, nil
+}
+
+func (h *KafkaHandler) handleUnlockAccount(ctx context.Context, unlockReq models.UnlockAccountRequest) (any, error) {
+	if err := h.userService.UnlockAccount(unlockReq.ActorID, unlockReq.Email); err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"message": "Account unlocked successfully",
+	}, nil
+}
+
+func (h *KafkaHandler) handleSearchUsers(ctx context.Context, searchReq models.SearchUsersRequest) (any, error) {
+	page, err := h.userService.SearchUsers(searchReq.Query, searchReq.Limit, searchReq.Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+func (h *KafkaHandler) handleDisableUser(ctx context.Context, disableReq models.DisableUserRequest) (any, error) {
+	user, err := h.userService.DisableUser(disableReq.ActorID, disableReq.UserID, disableReq.Reason)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"user":    user,
+		"message": "User disabled and logged out",
+	}, nil
+}
+
+func (h *KafkaHandler) handleEnableUser(ctx context.Context, enableReq models.AdminUserRequest) (any, error) {
+	user, err := h.userService.EnableUser(enableReq.ActorID, enableReq.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"user":    user,
+		"message": "User enabled",
+	}, nil
+}
+
+func (h *KafkaHandler) handleSetUserRoles(ctx context.Context, rolesReq models.SetUserRolesRequest) (any, error) {
+	roles, err := h.userService.SetUserRoles(rolesReq.ActorID, rolesReq.UserID, rolesReq.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"roles":   roles,
+		"message": "Roles updated, the user has to log in again",
+	}, nil
+}
+
+func (h *KafkaHandler) handleForcePasswordReset(ctx context.Context, resetReq models.AdminUserRequest) (any, error) {
+	if err := h.userService.ForcePasswordReset(resetReq.ActorID, resetReq.UserID); err != nil {
+		return nil, err
+	}
 
-	err := h.writer.WriteMessages(context.Background(),
-		kafka.Message{
-			Key:   []byte(response.CorrelationID),
-			Value: responseBytes,
-		},
-	)
+	return map[string]any{
+		"message": "Password reset link sent, the current password no longer works",
+	}, nil
+}
+
+func (h *KafkaHandler) handleForceLogout(ctx context.Context, logoutReq models.AdminUserRequest) (any, error) {
+	if err := h.userService.ForceLogout(logoutReq.ActorID, logoutReq.UserID); err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"message": "User logged out of all devices",
+	}, nil
+}
 
+func (h *KafkaHandler) handleListAudit(ctx context.Context, auditReq models.ListAuditRequest) (any, error) {
+	entries, err := h.userService.ListAudit(auditReq.UserID, auditReq.Limit, auditReq.Offset)
 	if err != nil {
-		log.Printf("Failed to send response: %v", err)
-	} else {
-		log.Printf("Successfully sent response to Kafka for correlationID: %s", response.CorrelationID)
+		return nil, err
 	}
+
+	return map[string]any{
+		"entries": entries,
+	}, nil
+}
+
+func (h *KafkaHandler) handleExportData(ctx context.Context, exportReq models.ExportDataRequest) (any, error) {
+	archive, err := h.userService.ExportData(ctx, exportReq.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return archive, nil
+}
+
+func (h *KafkaHandler) handleDeleteAccount(ctx context.Context, deleteReq models.DeleteAccountRequest) (any, error) {
+	if err := h.userService.DeleteAccount(deleteReq.UserID, deleteReq.Password); err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"message": "Account deleted successfully",
+	}, nil
 }
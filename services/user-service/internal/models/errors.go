@@ -0,0 +1,60 @@
+package models
+
+import sharedmodels "github.com/lucas/shared/models"
+
+// Error is a domain error: its message is safe to show to clients and its
+// code tells them what went wrong. Any other error is an internal failure.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorCode lets the RPC server answer with the error's code
+func (e *Error) ErrorCode() string {
+	return e.Code
+}
+
+var (
+	ErrInvalidRequest       = &Error{sharedmodels.ErrCodeInvalidRequest, "Invalid request format"}
+	ErrInvalidLogoutRequest = &Error{sharedmodels.ErrCodeInvalidRequest, "invalid logout request"}
+	ErrInvalidEmail         = &Error{sharedmodels.ErrCodeInvalidEmail, "invalid email format"}
+	ErrInvalidPassword      = &Error{sharedmodels.ErrCodeInvalidPassword, "invalid password format"}
+	ErrEmptyName            = &Error{sharedmodels.ErrCodeInvalidName, "name cannot be empty"}
+	ErrInvalidMFACode       = &Error{sharedmodels.ErrCodeInvalidMFACode, "invalid mfa code"}
+	ErrMFANotEnrolled       = &Error{sharedmodels.ErrCodeMFANotEnrolled, "mfa not enrolled"}
+	ErrInvalidAPIKeyName    = &Error{sharedmodels.ErrCodeInvalidAPIKeyRequest, "invalid api key name"}
+	ErrInvalidAPIKeyExpiry  = &Error{sharedmodels.ErrCodeInvalidAPIKeyRequest, "invalid api key expiry"}
+	ErrInvalidAPIKeyScopes  = &Error{sharedmodels.ErrCodeInvalidAPIKeyRequest, "invalid api key scopes"}
+	ErrTooManyAPIKeys       = &Error{sharedmodels.ErrCodeTooManyAPIKeys, "too many api keys"}
+	ErrInvalidOAuthState    = &Error{sharedmodels.ErrCodeInvalidOAuthState, "invalid oauth state"}
+	ErrNoUsableEmail        = &Error{sharedmodels.ErrCodeOAuthEmailMissing, "identity provider returned no usable email"}
+	ErrInvalidRole          = &Error{sharedmodels.ErrCodeInvalidRole, "invalid role"}
+	ErrCannotDisableSelf    = &Error{sharedmodels.ErrCodeCannotModifySelf, "cannot disable own account"}
+	ErrCannotChangeOwnRoles = &Error{sharedmodels.ErrCodeCannotModifySelf, "cannot change own roles"}
+
+	ErrInvalidCredentials  = &Error{sharedmodels.ErrCodeInvalidCredentials, "invalid credentials"}
+	ErrInvalidToken        = &Error{sharedmodels.ErrCodeInvalidToken, "invalid or expired token"}
+	ErrInvalidRefreshToken = &Error{sharedmodels.ErrCodeInvalidToken, "invalid refresh token"}
+	ErrRefreshTokenReused  = &Error{sharedmodels.ErrCodeInvalidToken, "refresh token reuse detected"}
+	ErrInvalidAPIKey       = &Error{sharedmodels.ErrCodeInvalidAPIKey, "invalid api key"}
+
+	ErrEmailNotVerified = &Error{sharedmodels.ErrCodeEmailNotVerified, "email not verified"}
+	ErrAccountDisabled  = &Error{sharedmodels.ErrCodeAccountDisabled, "account disabled"}
+
+	ErrUserNotFound            = &Error{sharedmodels.ErrCodeUserNotFound, "user not found"}
+	ErrSessionNotFound         = &Error{sharedmodels.ErrCodeSessionNotFound, "session not found"}
+	ErrAPIKeyNotFound          = &Error{sharedmodels.ErrCodeAPIKeyNotFound, "api key not found"}
+	ErrUnknownIdentityProvider = &Error{sharedmodels.ErrCodeUnknownIdentityProvider, "unknown identity provider"}
+
+	ErrEmailInUse            = &Error{sharedmodels.ErrCodeEmailInUse, "email already in use"}
+	ErrMFAAlreadyEnabled     = &Error{sharedmodels.ErrCodeMFAAlreadyEnabled, "mfa already enabled"}
+	ErrIdentityAlreadyLinked = &Error{sharedmodels.ErrCodeIdentityAlreadyLinked, "identity already linked"}
+
+	ErrTooManyLoginAttempts  = &Error{sharedmodels.ErrCodeTooManyLoginAttempts, "too many login attempts"}
+	ErrTooManyPasswordResets = &Error{sharedmodels.ErrCodeTooManyPasswordResets, "too many password reset requests"}
+	ErrOAuthFailed           = &Error{sharedmodels.ErrCodeIdentityProviderFailed, "oauth login failed"}
+)
@@ -4,22 +4,113 @@ import (
 	"time"
 )
 
+// Roles seeded by migration 003
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+)
+
 type User struct {
-	ID           int       `json:"id" db:"id"`
-	Email        string    `json:"email" db:"email"`
-	Name         string    `json:"name" db:"name"`
-	PasswordHash string    `json:"-" db:"password_hash"` // "-" excludes from JSON
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID              int        `json:"id" db:"id"`
+	Email           string     `json:"email" db:"email"`
+	Name            string     `json:"name" db:"name"`
+	PasswordHash    string     `json:"-" db:"password_hash"`                       // "-" excludes from JSON
+	PendingEmail    string     `json:"pending_email,omitempty" db:"pending_email"` // Awaiting confirmation
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty" db:"email_verified_at"`
+	MFASecret       string     `json:"-" db:"mfa_secret"` // TOTP secret, set from enrollment on
+	MFAEnabledAt    *time.Time `json:"mfa_enabled_at,omitempty" db:"mfa_enabled_at"`
+	DisabledAt      *time.Time `json:"disabled_at,omitempty" db:"disabled_at"` // Set by an admin, blocks sign-in
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
+	Roles           []string   `json:"roles,omitempty" db:"-"` // Loaded from user_roles when needed
 }
 
 type Session struct {
-    ID        string    `json:"session_id"`
-    UserID    string    `json:"user_id"`
-    CreatedAt time.Time `json:"created_at"`
-    ExpiresAt time.Time `json:"expires_at"`
-    IPAddress string    `json:"ip_address,omitempty"`
-    UserAgent string    `json:"user_agent,omitempty"`
+	ID        string    `json:"session_id"`
+	UserID    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	MFA       bool      `json:"mfa,omitempty"` // Logged in with a second factor
+}
+
+// APIKey is a long-lived credential for machine clients. It acts as its user,
+// limited to Scopes. The secret itself is only shown once, at creation.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	SecretHash string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// UserIdentity is an external identity provider account linked to a user
+type UserIdentity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Actions recorded in the audit trail
+const (
+	AuditUserDisabled        = "user.disabled"
+	AuditUserEnabled         = "user.enabled"
+	AuditRolesChanged        = "user.roles_changed"
+	AuditPasswordResetForced = "user.password_reset_forced"
+	AuditLogoutForced        = "user.logout_forced"
+	AuditAccountUnlocked     = "user.unlocked"
+)
+
+// AuditEntry records an administrative action taken on a user account
+type AuditEntry struct {
+	ID           int64          `json:"id"`
+	ActorID      int            `json:"actor_id"`
+	Action       string         `json:"action"`
+	TargetUserID *int           `json:"target_user_id,omitempty"`
+	Details      map[string]any `json:"details,omitempty"`
+	CreatedAt    time.Time      `json:"created_at"`
+}
+
+// UserPage is one page of a user search
+type UserPage struct {
+	Users  []*User `json:"users"`
+	Total  int     `json:"total"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+}
+
+// LoginResult is either a new session or, for accounts with two-factor
+// authentication, a challenge to complete with a code first
+type LoginResult struct {
+	Session      *Session
+	Tokens       *TokenPair
+	MFAChallenge *MFAChallenge
+}
+
+type MFAChallenge struct {
+	Token     string `json:"mfa_token"`
+	ExpiresIn int    `json:"expires_in"` // Challenge lifetime in seconds
+}
+
+// MFAEnrollment is returned when enrollment starts, before the first code is confirmed
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TokenPair is what a client receives on login or refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // Access token lifetime in seconds
+	RefreshToken string `json:"refresh_token"`
 }
 
 type RegisterUserRequest struct {
@@ -29,11 +120,51 @@ type RegisterUserRequest struct {
 }
 
 type LoginUserRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"` // Plain password, will be hashed
+	Email     string `json:"email"`
+	Password  string `json:"password"` // Plain password, will be hashed
+	IPAddress string `json:"ip_address"`
+	UserAgent string `json:"user_agent"`
+}
+
+type VerifyMFARequest struct {
+	MFAToken  string `json:"mfa_token"`
+	Code      string `json:"code"` // TOTP code or recovery code
+	IPAddress string `json:"ip_address"`
+	UserAgent string `json:"user_agent"`
+}
+
+type CreateAPIKeyRequest struct {
+	UserID    string     `json:"user_id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type OAuthCallbackRequest struct {
+	Provider  string `json:"provider"`
+	Code      string `json:"code"`
+	State     string `json:"state"`
+	IPAddress string `json:"ip_address"`
+	UserAgent string `json:"user_agent"`
+}
+
+type LogoutUserRequest struct {
+	UserID         string    `json:"user_id"`
+	SessionID      string    `json:"session_id"`
+	TokenID        string    `json:"token_id"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
+	AllDevices     bool      `json:"all_devices"`
+}
+
+// UpdateProfileRequest changes only the fields that are set
+type UpdateProfileRequest struct {
+	UserID string  `json:"user_id"`
+	Name   *string `json:"name,omitempty"`
+	Email  *string `json:"email,omitempty"`
 }
 
-// Just for testing, delete later
-type GetProfileRequest struct {
-	Email string `json:"email"`
+type ChangePasswordRequest struct {
+	UserID          string `json:"user_id"`
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
 }
@@ -0,0 +1,233 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lucas/shared/utils"
+)
+
+// Provider is an external identity provider signed in to with the OAuth2
+// authorization code flow and PKCE. Every endpoint is configurable so any
+// OpenID Connect provider, including a local stand-in, can be used.
+type Provider struct {
+	Name         string
+	ClientID     string
+	ClientSecret string
+	AuthURL      string
+	TokenURL     string
+	UserInfoURL  string
+	RedirectURL  string
+	Scopes       []string
+
+	httpClient *http.Client
+}
+
+// UserInfo is the identity returned by the provider's userinfo endpoint
+// (OpenID Connect standard claims).
+type UserInfo struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// LoadProviders reads the providers listed in OIDC_PROVIDERS (comma-separated
+// names). For a provider "google" the settings are OIDC_GOOGLE_CLIENT_ID,
+// OIDC_GOOGLE_CLIENT_SECRET, OIDC_GOOGLE_REDIRECT_URL, OIDC_GOOGLE_SCOPES and
+// either OIDC_GOOGLE_ISSUER, whose discovery document supplies the endpoints,
+// or OIDC_GOOGLE_AUTH_URL, OIDC_GOOGLE_TOKEN_URL and OIDC_GOOGLE_USERINFO_URL.
+// Explicit endpoints win over discovered ones. Misconfigured providers are skipped.
+func LoadProviders() map[string]*Provider {
+	providers := make(map[string]*Provider)
+	for _, name := range utils.GetEnvListOrDefault("OIDC_PROVIDERS", nil) {
+		name = strings.ToLower(name)
+		provider, err := loadProvider(name)
+		if err != nil {
+			log.Printf("Skipping identity provider %s: %v", name, err)
+			continue
+		}
+		providers[name] = provider
+		log.Printf("Identity provider %s configured", name)
+	}
+	return providers
+}
+
+func loadProvider(name string) (*Provider, error) {
+	env := func(key string) string {
+		return utils.GetEnvOrDefault("OIDC_"+strings.ToUpper(name)+"_"+key, "")
+	}
+
+	p := &Provider{
+		Name:         name,
+		ClientID:     env("CLIENT_ID"),
+		ClientSecret: env("CLIENT_SECRET"),
+		AuthURL:      env("AUTH_URL"),
+		TokenURL:     env("TOKEN_URL"),
+		UserInfoURL:  env("USERINFO_URL"),
+		RedirectURL:  env("REDIRECT_URL"),
+		Scopes:       []string{"openid", "email", "profile"},
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if scopes := env("SCOPES"); scopes != "" {
+		p.Scopes = strings.Fields(strings.ReplaceAll(scopes, ",", " "))
+	}
+	if p.RedirectURL == "" {
+		p.RedirectURL = "http://localhost:8080/api/v1/auth/oauth/" + name + "/callback"
+	}
+
+	if issuer := env("ISSUER"); issuer != "" {
+		if err := p.discover(issuer); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.ClientID == "" || p.AuthURL == "" || p.TokenURL == "" || p.UserInfoURL == "" {
+		return nil, errors.New("client ID and auth, token and userinfo endpoints are required")
+	}
+	return p, nil
+}
+
+// discover fills the endpoints that weren't set explicitly from the issuer's
+// OpenID Connect discovery document.
+func (p *Provider) discover(issuer string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var doc struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	}
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, "", &doc); err != nil {
+		return fmt.Errorf("discovery failed: %w", err)
+	}
+
+	if p.AuthURL == "" {
+		p.AuthURL = doc.AuthorizationEndpoint
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = doc.TokenEndpoint
+	}
+	if p.UserInfoURL == "" {
+		p.UserInfoURL = doc.UserInfoEndpoint
+	}
+	return nil
+}
+
+// AuthCodeURL is where the user is sent to sign in. The PKCE challenge is
+// derived from verifier, which must be kept until Exchange.
+func (p *Provider) AuthCodeURL(state, verifier string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientID)
+	params.Set("redirect_uri", p.RedirectURL)
+	params.Set("scope", strings.Join(p.Scopes, " "))
+	params.Set("state", state)
+	params.Set("code_challenge", pkceChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		separator = "&"
+	}
+	return p.AuthURL + separator + params.Encode()
+}
+
+// Exchange trades an authorization code for an access token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", verifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", fmt.Errorf("token request rejected with status %d: %s", resp.StatusCode, token.Error)
+	}
+	return token.AccessToken, nil
+}
+
+// UserInfo fetches the signed-in user's identity with an access token from Exchange.
+func (p *Provider) UserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	var info UserInfo
+	if err := p.getJSON(ctx, p.UserInfoURL, accessToken, &info); err != nil {
+		return nil, fmt.Errorf("userinfo request failed: %w", err)
+	}
+	if info.Subject == "" {
+		return nil, errors.New("userinfo response has no subject")
+	}
+	return &info, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// NewPKCEVerifier generates a PKCE code verifier (RFC 7636).
+func NewPKCEVerifier() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate PKCE verifier: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/lucas/user-service/internal/models"
+)
+
+// withRoles scans the user's roles after the user columns
+type withRoles struct {
+	row   rowScanner
+	roles *[]string
+	total *int
+}
+
+func (w withRoles) Scan(dest ...any) error {
+	return w.row.Scan(append(dest, pq.Array(w.roles), w.total)...)
+}
+
+// SearchUsers returns a page of users whose email or name contains query,
+// newest first, with their roles and the number of matches overall.
+func (r *UserRepository) SearchUsers(query string, limit, offset int) ([]*models.User, int, error) {
+	pattern := "%" + escapeLike(strings.ToLower(query)) + "%"
+	sqlQuery := `
+		SELECT ` + userColumns + `,
+			(SELECT COALESCE(ARRAY_AGG(ro.name ORDER BY ro.name), '{}')
+			 FROM user_roles ur JOIN roles ro ON ro.id = ur.role_id
+			 WHERE ur.user_id = users.id),
+			COUNT(*) OVER ()
+		FROM users
+		WHERE LOWER(email) LIKE $1 OR LOWER(name) LIKE $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Query(sqlQuery, pattern, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	total := 0
+	for rows.Next() {
+		var roles []string
+		user, err := scanUser(withRoles{row: rows, roles: &roles, total: &total})
+		if err != nil {
+			return nil, 0, err
+		}
+		user.Roles = roles
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Past the last page there are no rows to carry the count
+	if len(users) == 0 && offset > 0 {
+		err := r.db.QueryRow(`SELECT COUNT(*) FROM users WHERE LOWER(email) LIKE $1 OR LOWER(name) LIKE $1`, pattern).Scan(&total)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return users, total, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SetUserDisabled disables or re-enables sign-in for the user.
+func (r *UserRepository) SetUserDisabled(id string, disabled bool) (*models.User, error) {
+	query := `
+		UPDATE users SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END, updated_at = NOW()
+		WHERE id = $1
+		RETURNING ` + userColumns
+	return scanUser(r.db.QueryRow(query, id, disabled))
+}
+
+// SetUserRoles replaces the user's roles in one transaction.
+func (r *UserRepository) SetUserRoles(id int, roles []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_roles WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if err := assignRole(tx, id, role); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RecordAudit appends an entry to the audit trail.
+func (r *UserRepository) RecordAudit(entry *models.AuditEntry) error {
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+	if entry.Details == nil {
+		details = []byte("{}")
+	}
+
+	query := `
+		INSERT INTO audit_log (actor_id, action, target_user_id, details)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+	return r.db.QueryRow(query, entry.ActorID, entry.Action, entry.TargetUserID, details).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// ListAudit returns audit entries, newest first, optionally only those about targetUserID.
+func (r *UserRepository) ListAudit(targetUserID string, limit, offset int) ([]*models.AuditEntry, error) {
+	query := `
+		SELECT id, actor_id, action, target_user_id, details, created_at FROM audit_log
+		WHERE $1 = '' OR target_user_id::TEXT = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.Query(query, targetUserID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*models.AuditEntry{}
+	for rows.Next() {
+		var entry models.AuditEntry
+		var target sql.NullInt64
+		var details []byte
+		if err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &target, &details, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		if target.Valid {
+			id := int(target.Int64)
+			entry.TargetUserID = &id
+		}
+		if err := json.Unmarshal(details, &entry.Details); err != nil {
+			return nil, fmt.Errorf("invalid details in audit entry %d: %w", entry.ID, err)
+		}
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
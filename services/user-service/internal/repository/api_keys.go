@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/lucas/shared/database"
+	"github.com/lucas/user-service/internal/models"
+)
+
+const apiKeyColumns = `id, user_id, name, prefix, secret_hash, scopes, expires_at, last_used_at, created_at`
+
+// CreateAPIKey stores a new API key and returns it as stored.
+func (r *UserRepository) CreateAPIKey(key *models.APIKey) (*models.APIKey, error) {
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, secret_hash, scopes, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW())
+		RETURNING ` + apiKeyColumns
+
+	var expiresAt sql.NullTime
+	if key.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: key.ExpiresAt.UTC(), Valid: true}
+	}
+
+	return scanAPIKey(r.db.QueryRow(query, key.UserID, key.Name, key.Prefix, key.SecretHash, pq.Array(key.Scopes), expiresAt))
+}
+
+// ListAPIKeys returns the user's keys that are neither revoked nor expired, newest first.
+func (r *UserRepository) ListAPIKeys(userID string) ([]*models.APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + ` FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW() AT TIME ZONE 'UTC')
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// GetActiveAPIKey finds a key by prefix, or returns "api key not found" when
+// it doesn't exist, was revoked or has expired.
+func (r *UserRepository) GetActiveAPIKey(prefix string) (*models.APIKey, error) {
+	query := `
+		SELECT ` + apiKeyColumns + ` FROM api_keys
+		WHERE prefix = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW() AT TIME ZONE 'UTC')
+	`
+
+	return scanAPIKey(r.db.QueryRow(query, prefix))
+}
+
+// TouchAPIKey records that a key was just used. Writes are limited to one a
+// minute per key so busy clients don't turn every request into an UPDATE.
+func (r *UserRepository) TouchAPIKey(id int) error {
+	query := `
+		UPDATE api_keys SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`
+
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
+// RevokeAPIKey revokes one of the user's keys.
+func (r *UserRepository) RevokeAPIKey(userID string, id int) error {
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	result, err := r.db.Exec(query, id, userID)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return models.ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// MarkAPIKeyRevoked tells the gateway a key was revoked, so it stops
+// accepting the key from its cache. The marker must outlive that cache.
+func (r *UserRepository) MarkAPIKeyRevoked(id string, ttl time.Duration) error {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return errors.New("redis client not available")
+	}
+
+	if err := redisClient.Set(context.Background(), "revoked_api_key:"+id, "true", ttl).Err(); err != nil {
+		return fmt.Errorf("failed to mark api key as revoked: %w", err)
+	}
+	return nil
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.SecretHash,
+		pq.Array(&key.Scopes),
+		&expiresAt,
+		&lastUsedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, models.ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return &key, nil
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/lib/pq"
+	"github.com/lucas/shared/database"
+	"github.com/lucas/user-service/internal/models"
+)
+
+// GetUserByIdentity finds the user linked to an external identity.
+func (r *UserRepository) GetUserByIdentity(provider, subject string) (*models.User, error) {
+	query := `
+		SELECT ` + userColumns + ` FROM users
+		WHERE id = (SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2)
+	`
+
+	return scanUser(r.db.QueryRow(query, provider, subject))
+}
+
+// LinkIdentity attaches an external identity to an existing user.
+func (r *UserRepository) LinkIdentity(userID int, provider, subject, email string) error {
+	return insertIdentity(r.db, userID, provider, subject, email)
+}
+
+// CreateExternalUser creates a customer signed up through an identity
+// provider, linked to that identity. passwordHash should not match any
+// password: such users set one through the password reset flow if they want.
+func (r *UserRepository) CreateExternalUser(email, name, passwordHash string, emailVerified bool, provider, subject string) (*models.User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users (email, name, password_hash, email_verified_at, created_at, updated_at)
+		VALUES ($1, $2, $3, CASE WHEN $4 THEN NOW() END, NOW(), NOW())
+		RETURNING ` + userColumns
+
+	user, err := scanUser(tx.QueryRow(query, email, name, passwordHash, emailVerified))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, models.ErrEmailInUse
+		}
+		return nil, err
+	}
+
+	if err := assignRole(tx, user.ID, models.RoleCustomer); err != nil {
+		return nil, err
+	}
+	if err := insertIdentity(tx, user.ID, provider, subject, email); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	user.Roles = []string{models.RoleCustomer}
+	return user, nil
+}
+
+func insertIdentity(db execer, userID int, provider, subject, email string) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email, created_at)
+		VALUES ($1, $2, $3, $4, NOW())
+	`
+
+	if _, err := db.Exec(query, userID, provider, subject, email); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return models.ErrIdentityAlreadyLinked
+		}
+		return err
+	}
+	return nil
+}
+
+// StoreOAuthState keeps the PKCE verifier of a sign-in started with provider
+// until the provider redirects back with state. linkUserID is the user the
+// identity is linked to, empty for a plain sign-in.
+func (r *UserRepository) StoreOAuthState(state, provider, verifier, linkUserID string, ttl time.Duration) error {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return errors.New("redis client not available")
+	}
+
+	ctx := context.Background()
+	pipe := redisClient.TxPipeline()
+	pipe.HSet(ctx, oauthStateKey(state), "provider", provider, "verifier", verifier, "link_user_id", linkUserID)
+	pipe.Expire(ctx, oauthStateKey(state), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to store oauth state: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOAuthState returns and forgets the sign-in started with state, or
+// "invalid oauth state" when it is unknown, expired or already used.
+func (r *UserRepository) ConsumeOAuthState(state string) (provider, verifier, linkUserID string, err error) {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return "", "", "", errors.New("redis client not available")
+	}
+
+	ctx := context.Background()
+	pipe := redisClient.TxPipeline()
+	get := pipe.HGetAll(ctx, oauthStateKey(state))
+	pipe.Del(ctx, oauthStateKey(state))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return "", "", "", fmt.Errorf("failed to load oauth state: %w", err)
+	}
+
+	values := get.Val()
+	if values["provider"] == "" || values["verifier"] == "" {
+		return "", "", "", models.ErrInvalidOAuthState
+	}
+	return values["provider"], values["verifier"], values["link_user_id"], nil
+}
+
+func oauthStateKey(state string) string {
+	return "oauth_state:" + state
+}
+
+// ListIdentities returns the external identities linked to the user.
+func (r *UserRepository) ListIdentities(userID string) ([]*models.UserIdentity, error) {
+	query := `
+		SELECT provider, subject, COALESCE(email, ''), created_at FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*models.UserIdentity{}
+	for rows.Next() {
+		var identity models.UserIdentity
+		if err := rows.Scan(&identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+	return identities, rows.Err()
+}
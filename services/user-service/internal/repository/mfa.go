@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lucas/shared/database"
+	"github.com/lucas/user-service/internal/models"
+)
+
+// SetMFASecret stores the TOTP secret of an enrollment in progress. It
+// refuses to overwrite the secret of an account that already has MFA enabled.
+func (r *UserRepository) SetMFASecret(id string, secret string) error {
+	query := `UPDATE users SET mfa_secret = $2, updated_at = NOW() WHERE id = $1 AND mfa_enabled_at IS NULL`
+
+	result, err := r.db.Exec(query, id, secret)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return models.ErrMFAAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableMFA turns on MFA for the enrolled secret and replaces the user's
+// recovery codes with recoveryCodeHashes, in one transaction.
+func (r *UserRepository) EnableMFA(id string, recoveryCodeHashes []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE users SET mfa_enabled_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND mfa_secret IS NOT NULL AND mfa_enabled_at IS NULL
+	`, id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return models.ErrMFAAlreadyEnabled
+	}
+
+	if _, err := tx.Exec(`DELETE FROM mfa_recovery_codes WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.Exec(`INSERT INTO mfa_recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, NOW())`, id, hash); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeRecoveryCode marks the unused recovery code matching codeHash as
+// used. It reports false when there is no such code.
+func (r *UserRepository) ConsumeRecoveryCode(id string, codeHash string) (bool, error) {
+	query := `UPDATE mfa_recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	result, err := r.db.Exec(query, id, codeHash)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+// MarkTOTPStepUsed records that the user's TOTP code for step was accepted
+// and reports false when it already was, so a code can't be replayed.
+func (r *UserRepository) MarkTOTPStepUsed(id string, step int64, ttl time.Duration) (bool, error) {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return false, errors.New("redis client not available")
+	}
+
+	ok, err := redisClient.SetNX(context.Background(), totpUsedKey(id, step), "used", ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to record TOTP step: %w", err)
+	}
+	return ok, nil
+}
+
+// StoreMFAChallenge registers the ID of a freshly issued MFA challenge so it
+// can be exchanged for a session exactly once.
+func (r *UserRepository) StoreMFAChallenge(tokenID string, ttl time.Duration) error {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return errors.New("redis client not available")
+	}
+
+	if err := redisClient.Set(context.Background(), mfaChallengeKey(tokenID), "1", ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store MFA challenge: %w", err)
+	}
+	return nil
+}
+
+// ConsumeMFAChallenge redeems a challenge, reporting false if it was already
+// redeemed or has expired.
+func (r *UserRepository) ConsumeMFAChallenge(tokenID string) (bool, error) {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return false, errors.New("redis client not available")
+	}
+
+	deleted, err := redisClient.Del(context.Background(), mfaChallengeKey(tokenID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to consume MFA challenge: %w", err)
+	}
+	return deleted == 1, nil
+}
+
+func mfaChallengeKey(tokenID string) string {
+	return "mfa_challenge:" + tokenID
+}
+
+func totpUsedKey(id string, step int64) string {
+	return "totp_used:" + id + ":" + strconv.FormatInt(step, 10)
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lucas/user-service/internal/models"
+)
+
+// CreatePasswordResetToken stores the hash of a reset token for userID. Older
+// tokens of the user stay valid until they expire or one of them is used.
+func (r *UserRepository) CreatePasswordResetToken(userID int, tokenHash string, ttl time.Duration) error {
+	query := `
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, NOW() + $3 * INTERVAL '1 second', NOW())
+	`
+
+	_, err := r.db.Exec(query, userID, tokenHash, int(ttl.Seconds()))
+	return err
+}
+
+// ResetPassword redeems the reset token matching tokenHash and sets the new
+// password in one transaction. Redeeming a token burns every other outstanding
+// token of the user. Returns the user ID, or "invalid or expired token" when
+// the token is unknown, already used or expired.
+func (r *UserRepository) ResetPassword(tokenHash string, passwordHash string) (string, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRow(`
+		UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+	`, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", models.ErrInvalidToken
+		}
+		return "", err
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET password_hash = $2, updated_at = NOW() WHERE id = $1`, userID, passwordHash); err != nil {
+		return "", err
+	}
+
+	if _, err := tx.Exec(`UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
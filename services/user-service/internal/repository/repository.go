@@ -8,11 +8,15 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/lib/pq"
 	"github.com/lucas/shared/database"
+	"github.com/lucas/shared/outbox"
 	"github.com/lucas/user-service/internal/models"
 )
 
@@ -24,7 +28,16 @@ func NewUserRepository(db *sql.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) CreateUser(user *models.RegisterUserRequest, passwordHash string) (*models.User, error) {
+// CreateUser inserts the user and grants it the default customer role in one transaction.
+// The messages events builds for the new user are written to the outbox in
+// that transaction too, so they are published if and only if the user is created.
+func (r *UserRepository) CreateUser(user *models.RegisterUserRequest, passwordHash string, events func(user *models.User) ([]outbox.Message, error)) (*models.User, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO users (email, name, password_hash, created_at, updated_at)
 		VALUES ($1, $2, $3, NOW(), NOW())
@@ -32,7 +45,7 @@ func (r *UserRepository) CreateUser(user *models.RegisterUserRequest, passwordHa
 	`
 
 	var createdUser models.User
-	err := r.db.QueryRow(query, user.Email, user.Name, passwordHash).Scan(
+	err = tx.QueryRow(query, user.Email, user.Name, passwordHash).Scan(
 		&createdUser.ID,
 		&createdUser.Email,
 		&createdUser.Name,
@@ -42,74 +55,466 @@ func (r *UserRepository) CreateUser(user *models.RegisterUserRequest, passwordHa
 	if err != nil {
 		// Handle duplicate email error
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
-			return nil, errors.New("email already exists")
+			return nil, models.ErrEmailInUse
 		}
 		return nil, err
 	}
 
+	if err := assignRole(tx, createdUser.ID, models.RoleCustomer); err != nil {
+		return nil, err
+	}
+	createdUser.Roles = []string{models.RoleCustomer}
+
+	messages, err := events(&createdUser)
+	if err != nil {
+		return nil, err
+	}
+	if err := outbox.Enqueue(context.Background(), tx, messages...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return &createdUser, nil
 }
 
-func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
-	query := `
-		SELECT id, email, name, password_hash, created_at, updated_at
-		FROM users WHERE email = $1
-	`
+// userColumns is the column list scanUser expects
+const userColumns = `id, email, name, password_hash, pending_email, email_verified_at, mfa_secret, mfa_enabled_at, disabled_at, created_at, updated_at`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
+func scanUser(row rowScanner) (*models.User, error) {
 	var user models.User
-	err := r.db.QueryRow(query, email).Scan(
+	var pendingEmail sql.NullString
+	var emailVerifiedAt sql.NullTime
+	var mfaSecret sql.NullString
+	var mfaEnabledAt sql.NullTime
+	var disabledAt sql.NullTime
+	err := row.Scan(
 		&user.ID,
 		&user.Email,
 		&user.Name,
 		&user.PasswordHash,
+		&pendingEmail,
+		&emailVerifiedAt,
+		&mfaSecret,
+		&mfaEnabledAt,
+		&disabledAt,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("user not found")
+			return nil, models.ErrUserNotFound
 		}
 		return nil, err
 	}
+	user.PendingEmail = pendingEmail.String
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+	user.MFASecret = mfaSecret.String
+	if mfaEnabledAt.Valid {
+		user.MFAEnabledAt = &mfaEnabledAt.Time
+	}
+	if disabledAt.Valid {
+		user.DisabledAt = &disabledAt.Time
+	}
 	return &user, nil
 }
 
-func (r *UserRepository) CreateSession(user *models.User) (*models.Session, string, error) {
-	// Generate simple session ID using crypto/rand
-	sessionBytes := make([]byte, 16)
-	if _, err := rand.Read(sessionBytes); err != nil {
-		return nil, "", fmt.Errorf("failed to generate session ID: %w", err)
+func (r *UserRepository) GetUserByEmail(email string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+	return scanUser(r.db.QueryRow(query, email))
+}
+
+func (r *UserRepository) GetUserByID(id string) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	return scanUser(r.db.QueryRow(query, id))
+}
+
+func (r *UserRepository) UpdateName(id string, name string) (*models.User, error) {
+	query := `UPDATE users SET name = $2, updated_at = NOW() WHERE id = $1 RETURNING ` + userColumns
+	return scanUser(r.db.QueryRow(query, id, name))
+}
+
+// SetPendingEmail records an email change that still has to be confirmed.
+func (r *UserRepository) SetPendingEmail(id string, email string) (*models.User, error) {
+	query := `UPDATE users SET pending_email = $2, updated_at = NOW() WHERE id = $1 RETURNING ` + userColumns
+	return scanUser(r.db.QueryRow(query, id, email))
+}
+
+// ConfirmPendingEmail swaps in the pending email, provided it is still the one
+// the confirmation was issued for.
+func (r *UserRepository) ConfirmPendingEmail(id string, email string) (*models.User, error) {
+	query := `
+		UPDATE users SET email = pending_email, pending_email = NULL, email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND pending_email = $2
+		RETURNING ` + userColumns
+
+	user, err := scanUser(r.db.QueryRow(query, id, email))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, models.ErrEmailInUse
+		}
+		return nil, err
 	}
-	sessionID := hex.EncodeToString(sessionBytes)
+	return user, nil
+}
 
+func (r *UserRepository) UpdatePassword(id string, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $2, updated_at = NOW() WHERE id = $1`
+
+	result, err := r.db.Exec(query, id, passwordHash)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return models.ErrUserNotFound
+	}
+	return nil
+}
+
+// DeleteUser removes the user row and enqueues messages in the same
+// transaction. Roles, identities, API keys and MFA and password reset data go
+// with it through ON DELETE CASCADE.
+func (r *UserRepository) DeleteUser(id string, messages ...outbox.Message) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return models.ErrUserNotFound
+	}
+
+	if err := outbox.Enqueue(context.Background(), tx, messages...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MarkEmailVerified records that the user proved ownership of email, as long
+// as it is still the account's address.
+func (r *UserRepository) MarkEmailVerified(id string, email string) (*models.User, error) {
+	query := `
+		UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		WHERE id = $1 AND email = $2
+		RETURNING ` + userColumns
+	return scanUser(r.db.QueryRow(query, id, email))
+}
+
+// StoreVerificationToken registers the ID of a freshly issued email
+// verification token so it can be redeemed exactly once.
+func (r *UserRepository) StoreVerificationToken(tokenID string, ttl time.Duration) error {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return errors.New("redis client not available")
+	}
+
+	if err := redisClient.Set(context.Background(), verificationTokenKey(tokenID), "1", ttl).Err(); err != nil {
+		return fmt.Errorf("failed to store verification token: %w", err)
+	}
+	return nil
+}
+
+// ConsumeVerificationToken redeems a verification token ID, reporting false if
+// it was already used or has expired.
+func (r *UserRepository) ConsumeVerificationToken(tokenID string) (bool, error) {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return false, errors.New("redis client not available")
+	}
+
+	deleted, err := redisClient.Del(context.Background(), verificationTokenKey(tokenID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to consume verification token: %w", err)
+	}
+	return deleted == 1, nil
+}
+
+// CreateSession stores a new session together with the hash of its first
+// refresh token. Both live until the session's absolute expiry; refreshing
+// rotates the token but never extends the session.
+func (r *UserRepository) CreateSession(sessionID string, user *models.User, refreshTokenHash string, ttl time.Duration, ipAddress, userAgent string, mfa bool) (*models.Session, error) {
 	// Create session object
 	session := &models.Session{
 		ID:        sessionID,
 		UserID:    strconv.Itoa(user.ID),
 		CreatedAt: time.Now(),
-		ExpiresAt: time.Now().Add(24 * time.Hour), // 24 hour session
+		ExpiresAt: time.Now().Add(ttl),
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		MFA:       mfa,
 	}
 
 	// Store session in Redis
 	redisClient := database.GetRedisClient()
 	if redisClient == nil {
-		return nil, "", errors.New("redis client not available")
+		return nil, errors.New("redis client not available")
 	}
 
 	sessionJSON, err := json.Marshal(session)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to marshal session: %w", err)
+		return nil, fmt.Errorf("failed to marshal session: %w", err)
 	}
 
 	ctx := context.Background()
-	err = redisClient.Set(ctx, "session:"+sessionID, sessionJSON, 24*time.Hour).Err()
+	pipe := redisClient.TxPipeline()
+	pipe.Set(ctx, sessionKey(sessionID), sessionJSON, ttl)
+	pipe.Set(ctx, refreshTokenKey(sessionID), refreshTokenHash, ttl)
+	// The index outlives every session it lists; expired members are pruned when listed
+	pipe.SAdd(ctx, userSessionsKey(session.UserID), sessionID)
+	pipe.Expire(ctx, userSessionsKey(session.UserID), ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to store session in Redis: %w", err)
+	}
+
+	return session, nil
+}
+
+func (r *UserRepository) GetSession(sessionID string) (*models.Session, error) {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return nil, errors.New("redis client not available")
+	}
+
+	sessionJSON, err := redisClient.Get(context.Background(), sessionKey(sessionID)).Bytes()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to store session in Redis: %w", err)
+		if errors.Is(err, redis.Nil) {
+			return nil, models.ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to load session from Redis: %w", err)
 	}
 
-	// Generate simple token (for MVP - just the session ID)
-	// In production, you'd use a proper JWT library
-	token := sessionID
+	var session models.Session
+	if err := json.Unmarshal(sessionJSON, &session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+	}
+	return &session, nil
+}
+
+// DeleteSession removes a session and its whole refresh token family. The
+// gateway refuses access tokens of a session that no longer exists.
+func (r *UserRepository) DeleteSession(sessionID string) error {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return errors.New("redis client not available")
+	}
+
+	session, err := r.GetSession(sessionID)
+	if err != nil && !errors.Is(err, models.ErrSessionNotFound) {
+		return err
+	}
+
+	ctx := context.Background()
+	pipe := redisClient.TxPipeline()
+	pipe.Del(ctx,
+		sessionKey(sessionID),
+		refreshTokenKey(sessionID),
+		usedRefreshTokensKey(sessionID),
+	)
+	if session != nil {
+		pipe.SRem(ctx, userSessionsKey(session.UserID), sessionID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete session from Redis: %w", err)
+	}
+	return nil
+}
+
+// ListSessions returns the user's sessions that haven't expired, oldest first.
+// Sessions that expired on their own are dropped from the index on the way.
+func (r *UserRepository) ListSessions(userID string) ([]*models.Session, error) {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return nil, errors.New("redis client not available")
+	}
+
+	ctx := context.Background()
+	sessionIDs, err := redisClient.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	if len(sessionIDs) == 0 {
+		return []*models.Session{}, nil
+	}
+
+	keys := make([]string, len(sessionIDs))
+	for i, id := range sessionIDs {
+		keys[i] = sessionKey(id)
+	}
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sessions: %w", err)
+	}
+
+	sessions := make([]*models.Session, 0, len(values))
+	var expired []any
+	for i, value := range values {
+		sessionJSON, ok := value.(string)
+		if !ok {
+			expired = append(expired, sessionIDs[i])
+			continue
+		}
+
+		var session models.Session
+		if err := json.Unmarshal([]byte(sessionJSON), &session); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal session: %w", err)
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if len(expired) > 0 {
+		if err := redisClient.SRem(ctx, userSessionsKey(userID), expired...).Err(); err != nil {
+			log.Printf("Failed to prune expired sessions of user %s: %v", userID, err)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return sessions, nil
+}
+
+// BlacklistToken revokes a single access token until it would have expired anyway.
+func (r *UserRepository) BlacklistToken(tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return errors.New("redis client not available")
+	}
+
+	if err := redisClient.Set(context.Background(), "blacklist:"+tokenID, "true", ttl).Err(); err != nil {
+		return fmt.Errorf("failed to blacklist token: %w", err)
+	}
+	return nil
+}
+
+// RevokeSessionsUntil invalidates every token and session of the user issued
+// before at. The cutoff is stored in Unix milliseconds, so a sign-in right
+// after a password change or reset isn't caught by it. The marker only needs to outlive the longest session, after which
+// everything it covers has expired by itself.
+func (r *UserRepository) RevokeSessionsUntil(userID string, at time.Time, sessionTTL time.Duration) error {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return errors.New("redis client not available")
+	}
+
+	err := redisClient.Set(context.Background(), revokedAfterKey(userID), strconv.FormatInt(at.UnixMilli(), 10), sessionTTL).Err()
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// GetSessionsRevokedUntil returns the cutoff set by RevokeSessionsUntil, or
+// the zero time when the user never logged out everywhere.
+func (r *UserRepository) GetSessionsRevokedUntil(userID string) (time.Time, error) {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return time.Time{}, errors.New("redis client not available")
+	}
+
+	value, err := redisClient.Get(context.Background(), revokedAfterKey(userID)).Int64()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed to load session revocation: %w", err)
+	}
+	return time.UnixMilli(value), nil
+}
+
+type RefreshResult int
+
+const (
+	RefreshInvalid RefreshResult = iota // Unknown token or expired session
+	RefreshRotated                      // Token was current and has been replaced
+	RefreshReused                       // Token was already rotated away: replay
+)
+
+// rotateRefreshTokenScript swaps the current refresh token hash for a new one
+// if the presented hash is current, remembering the old one so a replay can be
+// told apart from garbage. KEYS: current hash, used hashes. ARGV: presented, new.
+var rotateRefreshTokenScript = redis.NewScript(`
+local current = redis.call('GET', KEYS[1])
+if not current then
+	return 0
+end
+if current == ARGV[1] then
+	local ttl = redis.call('PTTL', KEYS[1])
+	redis.call('SET', KEYS[1], ARGV[2], 'KEEPTTL')
+	redis.call('SADD', KEYS[2], ARGV[1])
+	redis.call('PEXPIRE', KEYS[2], ttl)
+	return 1
+end
+if redis.call('SISMEMBER', KEYS[2], ARGV[1]) == 1 then
+	return 2
+end
+return 0
+`)
+
+// RotateRefreshToken atomically replaces the session's refresh token hash.
+func (r *UserRepository) RotateRefreshToken(sessionID, presentedHash, newHash string) (RefreshResult, error) {
+	redisClient := database.GetRedisClient()
+	if redisClient == nil {
+		return RefreshInvalid, errors.New("redis client not available")
+	}
+
+	result, err := rotateRefreshTokenScript.Run(context.Background(), redisClient,
+		[]string{refreshTokenKey(sessionID), usedRefreshTokensKey(sessionID)},
+		presentedHash, newHash,
+	).Int()
+	if err != nil {
+		return RefreshInvalid, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	return RefreshResult(result), nil
+}
+
+func NewSessionID() (string, error) {
+	// Generate simple session ID using crypto/rand
+	sessionBytes := make([]byte, 16)
+	if _, err := rand.Read(sessionBytes); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	return hex.EncodeToString(sessionBytes), nil
+}
+
+func sessionKey(sessionID string) string {
+	return "session:" + sessionID
+}
+
+func userSessionsKey(userID string) string {
+	return "user_sessions:" + userID
+}
+
+func refreshTokenKey(sessionID string) string {
+	return "refresh:" + sessionID
+}
+
+func usedRefreshTokensKey(sessionID string) string {
+	return "refresh_used:" + sessionID
+}
+
+func verificationTokenKey(tokenID string) string {
+	return "email_verification:" + tokenID
+}
 
-	return session, token, nil
+// revokedAfterKey is shared with the gateway's AuthMiddleware, which rejects
+// tokens issued at or before the stored unix time.
+func revokedAfterKey(userID string) string {
+	return "revoked_after:" + userID
 }
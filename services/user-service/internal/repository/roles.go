@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// GetUserAccess returns the names of the user's roles and of every permission those roles grant.
+func (r *UserRepository) GetUserAccess(userID string) (roles []string, permissions []string, err error) {
+	query := `
+		SELECT
+			COALESCE(ARRAY_AGG(DISTINCT r.name) FILTER (WHERE r.name IS NOT NULL), '{}'),
+			COALESCE(ARRAY_AGG(DISTINCT p.name) FILTER (WHERE p.name IS NOT NULL), '{}')
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = $1
+	`
+
+	err = r.db.QueryRow(query, userID).Scan(pq.Array(&roles), pq.Array(&permissions))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load user roles: %w", err)
+	}
+	return roles, permissions, nil
+}
+
+func assignRole(db execer, userID int, role string) error {
+	query := `
+		INSERT INTO user_roles (user_id, role_id)
+		SELECT $1, id FROM roles WHERE name = $2
+	`
+
+	result, err := db.Exec(query, userID, role)
+	if err != nil {
+		return fmt.Errorf("failed to assign role %s: %w", role, err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("unknown role %s", role)
+	}
+	return nil
+}
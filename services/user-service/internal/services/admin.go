@@ -0,0 +1,218 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/lucas/user-service/internal/models"
+	"github.com/lucas/user-service/internal/tokens"
+)
+
+// Page sizes for admin listings
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// assignableRoles are the roles an admin may grant
+var assignableRoles = []string{models.RoleCustomer, models.RoleStaff, models.RoleAdmin}
+
+// SearchUsers returns a page of users whose email or name contains query.
+// An empty query lists every user.
+func (s *UserService) SearchUsers(query string, limit, offset int) (*models.UserPage, error) {
+	limit, offset = pageBounds(limit, offset)
+
+	users, total, err := s.userRepo.SearchUsers(query, limit, offset)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+
+	return &models.UserPage{Users: users, Total: total, Limit: limit, Offset: offset}, nil
+}
+
+// DisableUser blocks sign-in for the user and ends all of their sessions.
+func (s *UserService) DisableUser(actorID, userID, reason string) (*models.User, error) {
+	if actorID == userID {
+		return nil, models.ErrCannotDisableSelf
+	}
+	if _, err := s.targetUser(userID); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.SetUserDisabled(userID, true)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+	if err := s.endAllSessions(userID); err != nil {
+		return nil, err
+	}
+
+	s.audit(actorID, models.AuditUserDisabled, userID, map[string]any{"reason": reason})
+	return user, nil
+}
+
+func (s *UserService) EnableUser(actorID, userID string) (*models.User, error) {
+	if _, err := s.targetUser(userID); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.SetUserDisabled(userID, false)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+
+	s.audit(actorID, models.AuditUserEnabled, userID, nil)
+	return user, nil
+}
+
+// SetUserRoles replaces the user's roles. Access tokens carry the roles, so
+// the user's sessions are ended for the change to apply right away.
+func (s *UserService) SetUserRoles(actorID, userID string, roles []string) ([]string, error) {
+	if actorID == userID {
+		return nil, models.ErrCannotChangeOwnRoles
+	}
+	roles = slices.Clone(roles)
+	slices.Sort(roles)
+	roles = slices.Compact(roles)
+	if len(roles) == 0 {
+		return nil, models.ErrInvalidRole
+	}
+	for _, role := range roles {
+		if !slices.Contains(assignableRoles, role) {
+			return nil, models.ErrInvalidRole
+		}
+	}
+
+	user, err := s.targetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	previous, _, err := s.userRepo.GetUserAccess(userID)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+
+	if err := s.userRepo.SetUserRoles(user.ID, roles); err != nil {
+		return nil, errors.New("database error")
+	}
+	if err := s.endAllSessions(userID); err != nil {
+		return nil, err
+	}
+
+	s.audit(actorID, models.AuditRolesChanged, userID, map[string]any{"from": previous, "to": roles})
+	return roles, nil
+}
+
+// ForcePasswordReset makes the current password unusable, ends the user's
+// sessions and emails them a password reset link.
+func (s *UserService) ForcePasswordReset(actorID, userID string) error {
+	user, err := s.targetUser(userID)
+	if err != nil {
+		return err
+	}
+
+	// A random password nobody knows, so the reset link is the only way back in
+	unusable, _, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return errors.New("error hashing password")
+	}
+	hash, err := s.hashPassword(unusable)
+	if err != nil {
+		return errors.New("error hashing password")
+	}
+	if err := s.userRepo.UpdatePassword(userID, hash); err != nil {
+		return errors.New("database error")
+	}
+	if err := s.endAllSessions(userID); err != nil {
+		return err
+	}
+
+	if err := s.sendPasswordReset(user); err != nil {
+		log.Printf("Failed to send forced password reset for user %d: %v", user.ID, err)
+		return errors.New("error sending password reset")
+	}
+
+	s.audit(actorID, models.AuditPasswordResetForced, userID, nil)
+	return nil
+}
+
+// ForceLogout ends every session of the user.
+func (s *UserService) ForceLogout(actorID, userID string) error {
+	if _, err := s.targetUser(userID); err != nil {
+		return err
+	}
+	if err := s.endAllSessions(userID); err != nil {
+		return err
+	}
+
+	s.audit(actorID, models.AuditLogoutForced, userID, nil)
+	return nil
+}
+
+// ListAudit returns a page of the audit trail, optionally only about userID.
+func (s *UserService) ListAudit(userID string, limit, offset int) ([]*models.AuditEntry, error) {
+	limit, offset = pageBounds(limit, offset)
+
+	entries, err := s.userRepo.ListAudit(userID, limit, offset)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+	return entries, nil
+}
+
+// targetUser loads the user an admin action applies to
+func (s *UserService) targetUser(userID string) (*models.User, error) {
+	if _, err := strconv.Atoi(userID); err != nil {
+		return nil, models.ErrUserNotFound
+	}
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil, err
+		}
+		return nil, errors.New("database error")
+	}
+	return user, nil
+}
+
+// endAllSessions revokes every token issued to the user and removes their sessions.
+func (s *UserService) endAllSessions(userID string) error {
+	if err := s.userRepo.RevokeSessionsUntil(userID, time.Now(), s.config.SessionTTL); err != nil {
+		return errors.New("error revoking sessions")
+	}
+
+	sessions, err := s.userRepo.ListSessions(userID)
+	if err != nil {
+		return errors.New("error revoking sessions")
+	}
+	for _, session := range sessions {
+		if err := s.userRepo.DeleteSession(session.ID); err != nil {
+			return errors.New("error revoking sessions")
+		}
+	}
+	return nil
+}
+
+// audit records an admin action. The action has already happened by then, so
+// a failure is logged rather than returned.
+func (s *UserService) audit(actorID, action, userID string, details map[string]any) {
+	entry := &models.AuditEntry{Action: action, Details: details}
+	entry.ActorID, _ = strconv.Atoi(actorID)
+	if target, err := strconv.Atoi(userID); err == nil {
+		entry.TargetUserID = &target
+	}
+
+	if err := s.userRepo.RecordAudit(entry); err != nil {
+		log.Printf("AUDIT FAILED: %s by user %s on user %s (%v): %v", action, actorID, userID, details, err)
+	}
+}
+
+func pageBounds(limit, offset int) (int, int) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	return min(limit, maxPageSize), max(offset, 0)
+}
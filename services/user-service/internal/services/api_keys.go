@@ -0,0 +1,170 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	sharedmodels "github.com/lucas/shared/models"
+	"github.com/lucas/user-service/internal/models"
+	"github.com/lucas/user-service/internal/tokens"
+)
+
+// maxAPIKeysPerUser bounds how many active keys a user can hold
+const maxAPIKeysPerUser = 20
+
+// apiKeyRevocationTTL is how long a revoked key stays marked for the gateway,
+// well past how long the gateway caches a key
+const apiKeyRevocationTTL = time.Hour
+
+// CreateAPIKey issues a new API key for the user. Scopes must be permissions
+// the user currently holds. The returned key is the only time the secret is visible.
+func (s *UserService) CreateAPIKey(req *models.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return nil, "", models.ErrInvalidAPIKeyName
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", models.ErrInvalidAPIKeyExpiry
+	}
+
+	_, permissions, err := s.userRepo.GetUserAccess(req.UserID)
+	if err != nil {
+		return nil, "", errors.New("database error")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, "", models.ErrInvalidAPIKeyScopes
+	}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(permissions, scope) {
+			return nil, "", models.ErrInvalidAPIKeyScopes
+		}
+	}
+
+	existing, err := s.userRepo.ListAPIKeys(req.UserID)
+	if err != nil {
+		return nil, "", errors.New("database error")
+	}
+	if len(existing) >= maxAPIKeysPerUser {
+		return nil, "", models.ErrTooManyAPIKeys
+	}
+
+	userID, err := strconv.Atoi(req.UserID)
+	if err != nil {
+		return nil, "", models.ErrUserNotFound
+	}
+
+	scopes := slices.Clone(req.Scopes)
+	slices.Sort(scopes)
+
+	key, prefix, hash, err := tokens.NewAPIKey()
+	if err != nil {
+		return nil, "", errors.New("error creating api key")
+	}
+
+	apiKey, err := s.userRepo.CreateAPIKey(&models.APIKey{
+		UserID:     userID,
+		Name:       name,
+		Prefix:     prefix,
+		SecretHash: hash,
+		Scopes:     slices.Compact(scopes),
+		ExpiresAt:  req.ExpiresAt,
+	})
+	if err != nil {
+		return nil, "", errors.New("database error")
+	}
+
+	return apiKey, key, nil
+}
+
+func (s *UserService) ListAPIKeys(userID string) ([]*models.APIKey, error) {
+	keys, err := s.userRepo.ListAPIKeys(userID)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes one of the user's keys. The gateway stops accepting
+// it right away, cached or not.
+func (s *UserService) RevokeAPIKey(userID string, keyID string) error {
+	// Key IDs come straight from the URL; anything but a number names no key
+	id, err := strconv.Atoi(keyID)
+	if err != nil {
+		return models.ErrAPIKeyNotFound
+	}
+
+	if err := s.userRepo.RevokeAPIKey(userID, id); err != nil {
+		if errors.Is(err, models.ErrAPIKeyNotFound) {
+			return err
+		}
+		return errors.New("database error")
+	}
+
+	// The key is revoked either way; without the marker the gateway only
+	// notices once its cache entry expires
+	if err := s.userRepo.MarkAPIKeyRevoked(strconv.Itoa(id), apiKeyRevocationTTL); err != nil {
+		log.Printf("Failed to publish revocation of api key %s: %v", keyID, err)
+	}
+	return nil
+}
+
+// AuthenticateAPIKey resolves an API key to the identity the gateway
+// authorizes the request with. Its permissions are the key's scopes still
+// held by the user, so revoking a role also shrinks the user's keys.
+func (s *UserService) AuthenticateAPIKey(key string) (*sharedmodels.APIKeyIdentity, error) {
+	prefix, hash, err := tokens.ParseAPIKey(key)
+	if err != nil {
+		return nil, models.ErrInvalidAPIKey
+	}
+
+	apiKey, err := s.userRepo.GetActiveAPIKey(prefix)
+	if err != nil {
+		if errors.Is(err, models.ErrAPIKeyNotFound) {
+			return nil, models.ErrInvalidAPIKey
+		}
+		return nil, errors.New("database error")
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey.SecretHash), []byte(hash)) != 1 {
+		return nil, models.ErrInvalidAPIKey
+	}
+
+	userID := strconv.Itoa(apiKey.UserID)
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil, models.ErrInvalidAPIKey
+		}
+		return nil, errors.New("database error")
+	}
+	if user.DisabledAt != nil {
+		return nil, models.ErrInvalidAPIKey
+	}
+
+	roles, permissions, err := s.userRepo.GetUserAccess(userID)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+	scoped := make([]string, 0, len(apiKey.Scopes))
+	for _, scope := range apiKey.Scopes {
+		if slices.Contains(permissions, scope) {
+			scoped = append(scoped, scope)
+		}
+	}
+
+	if err := s.userRepo.TouchAPIKey(apiKey.ID); err != nil {
+		log.Printf("Failed to record use of api key %d: %v", apiKey.ID, err)
+	}
+
+	return &sharedmodels.APIKeyIdentity{
+		KeyID:       strconv.Itoa(apiKey.ID),
+		UserID:      userID,
+		Email:       user.Email,
+		Roles:       roles,
+		Permissions: scoped,
+	}, nil
+}
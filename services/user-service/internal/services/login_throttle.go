@@ -0,0 +1,125 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lucas/user-service/internal/models"
+	"github.com/lucas/user-service/internal/repository"
+)
+
+// loginFailureWindow is how long failed logins are remembered after the first one
+const loginFailureWindow = time.Hour
+
+// loginThrottle decides how long logins are refused after repeated failures.
+// The first freeAttempts failures cost nothing, then every failure doubles
+// the wait starting at baseDelay. From lockoutAfter failures on, logins are
+// locked for lockoutDuration.
+type loginThrottle struct {
+	freeAttempts    int64
+	baseDelay       time.Duration
+	lockoutAfter    int64
+	lockoutDuration time.Duration
+}
+
+var (
+	// Per account: a handful of typos is fine, guessing is not
+	accountThrottle = loginThrottle{freeAttempts: 3, baseDelay: 2 * time.Second, lockoutAfter: 10, lockoutDuration: 15 * time.Minute}
+	// Per client IP: looser since many users may share an address (NAT, offices)
+	ipThrottle = loginThrottle{freeAttempts: 20, baseDelay: time.Second, lockoutAfter: 100, lockoutDuration: 15 * time.Minute}
+)
+
+func (t loginThrottle) delay(failures int64) time.Duration {
+	switch {
+	case failures >= t.lockoutAfter:
+		return t.lockoutDuration
+	case failures <= t.freeAttempts:
+		return 0
+	}
+
+	// Compared before shifting: long doublings would overflow the duration
+	shift := failures - t.freeAttempts - 1
+	if shift >= 62 || t.baseDelay > t.lockoutDuration>>shift {
+		return t.lockoutDuration
+	}
+	return t.baseDelay << shift
+}
+
+// checkLoginAllowed fails while the account or the client IP is locked.
+func (s *UserService) checkLoginAllowed(email, ip string) error {
+	locked, err := s.isThrottled(loginThrottleKeys(email, ip))
+	if err != nil {
+		return errors.New("database error")
+	}
+	if locked {
+		return models.ErrTooManyLoginAttempts
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login against the account and the
+// client IP, locking each once its throttle says so. Unknown emails are
+// counted too so lockouts don't reveal which accounts exist.
+func (s *UserService) recordLoginFailure(email, ip string) {
+	for scope, key := range loginThrottleKeys(email, ip) {
+		throttle := accountThrottle
+		if scope == repository.LoginScopeIP {
+			throttle = ipThrottle
+		}
+		s.countThrottled(scope, key, throttle)
+	}
+}
+
+// isThrottled reports whether any of keys, by scope, is locked.
+func (s *UserService) isThrottled(keys map[string]string) (bool, error) {
+	for scope, key := range keys {
+		wait, err := s.userRepo.GetLoginLock(scope, key)
+		if err != nil {
+			return false, err
+		}
+		if wait > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// countThrottled counts an attempt against key within scope and locks it
+// once throttle says so.
+func (s *UserService) countThrottled(scope, key string, throttle loginThrottle) {
+	attempts, err := s.userRepo.RecordLoginFailure(scope, key, loginFailureWindow)
+	if err != nil {
+		log.Printf("Failed to record %s attempt: %v", scope, err)
+		return
+	}
+
+	if delay := throttle.delay(attempts); delay > 0 {
+		if err := s.userRepo.LockLogin(scope, key, delay); err != nil {
+			log.Printf("Failed to lock %s: %v", scope, err)
+		}
+	}
+}
+
+// UnlockAccount lifts a lockout on email and resets its failed login counter.
+func (s *UserService) UnlockAccount(actorID, email string) error {
+	if err := s.userRepo.ClearLoginFailures(repository.LoginScopeEmail, normalizeEmail(email)); err != nil {
+		return errors.New("database error")
+	}
+
+	s.audit(actorID, models.AuditAccountUnlocked, "", map[string]any{"email": normalizeEmail(email)})
+	return nil
+}
+
+func loginThrottleKeys(email, ip string) map[string]string {
+	keys := map[string]string{repository.LoginScopeEmail: normalizeEmail(email)}
+	if ip != "" {
+		keys[repository.LoginScopeIP] = ip
+	}
+	return keys
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottleDelay(t *testing.T) {
+	throttle := loginThrottle{freeAttempts: 3, baseDelay: 2 * time.Second, lockoutAfter: 10, lockoutDuration: 15 * time.Minute}
+
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{9, 64 * time.Second},
+		{10, 15 * time.Minute},
+		{1000, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := throttle.delay(tt.failures); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginThrottleDelayIsCappedAndMonotonic(t *testing.T) {
+	throttles := map[string]loginThrottle{
+		"account":     accountThrottle,
+		"ip":          ipThrottle,
+		"reset email": resetEmailThrottle,
+		"reset ip":    resetIPThrottle,
+	}
+	for name, throttle := range throttles {
+		t.Run(name, func(t *testing.T) {
+			var previous time.Duration
+			for failures := int64(0); failures <= throttle.lockoutAfter+1; failures++ {
+				d := throttle.delay(failures)
+				if d < previous || d > throttle.lockoutDuration {
+					t.Fatalf("delay(%d) = %v, want between %v and %v", failures, d, previous, throttle.lockoutDuration)
+				}
+				previous = d
+			}
+			if previous != throttle.lockoutDuration {
+				t.Errorf("delay past lockoutAfter = %v, want %v", previous, throttle.lockoutDuration)
+			}
+		})
+	}
+}
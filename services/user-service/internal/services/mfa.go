@@ -0,0 +1,177 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucas/user-service/internal/models"
+	"github.com/lucas/user-service/internal/tokens"
+)
+
+const (
+	// mfaChallengeTTL is how long a user has to enter the code after the password
+	mfaChallengeTTL = 5 * time.Minute
+	// recoveryCodeCount is how many recovery codes are issued when MFA is enabled
+	recoveryCodeCount = 10
+)
+
+// EnrollMFA generates a new TOTP secret for the user. MFA is only enabled
+// once a code from it is confirmed with ConfirmMFA.
+func (s *UserService) EnrollMFA(userID string) (*models.MFAEnrollment, error) {
+	user, err := s.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabledAt != nil {
+		return nil, models.ErrMFAAlreadyEnabled
+	}
+
+	secret, err := tokens.NewTOTPSecret()
+	if err != nil {
+		return nil, errors.New("error enrolling mfa")
+	}
+	if err := s.userRepo.SetMFASecret(userID, secret); err != nil {
+		if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+			return nil, err
+		}
+		return nil, errors.New("database error")
+	}
+
+	return &models.MFAEnrollment{
+		Secret: secret,
+		URI:    tokens.TOTPURI(s.config.MFAIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmMFA enables MFA once the user proves their authenticator produces
+// valid codes. It returns the recovery codes, which are never shown again.
+func (s *UserService) ConfirmMFA(userID string, code string) ([]string, error) {
+	user, err := s.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabledAt != nil {
+		return nil, models.ErrMFAAlreadyEnabled
+	}
+	if user.MFASecret == "" {
+		return nil, models.ErrMFANotEnrolled
+	}
+
+	if ok, err := s.checkTOTP(user, code); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, models.ErrInvalidMFACode
+	}
+
+	codes, err := tokens.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, errors.New("error enrolling mfa")
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = tokens.HashRecoveryCode(code)
+	}
+
+	if err := s.userRepo.EnableMFA(userID, hashes); err != nil {
+		if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+			return nil, err
+		}
+		return nil, errors.New("database error")
+	}
+
+	return codes, nil
+}
+
+// VerifyMFA completes a login started by LoginUser, accepting either a TOTP
+// code or one of the recovery codes. Failed codes count towards the account's
+// login throttle, so the second factor can't be brute forced either.
+func (s *UserService) VerifyMFA(req *models.VerifyMFARequest) (*models.LoginResult, error) {
+	claims, err := s.tokenIssuer.ParseMFAChallenge(req.MFAToken)
+	if err != nil {
+		return nil, models.ErrInvalidToken
+	}
+
+	user, err := s.userRepo.GetUserByID(claims.Subject)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil, models.ErrInvalidToken
+		}
+		return nil, errors.New("database error")
+	}
+	if user.MFAEnabledAt == nil {
+		return nil, models.ErrInvalidToken
+	}
+
+	if err := s.checkLoginAllowed(user.Email, req.IPAddress); err != nil {
+		return nil, err
+	}
+
+	// Redeem the challenge before the code so a replayed or concurrently
+	// submitted challenge can't burn a recovery code or TOTP step
+	redeemed, err := s.userRepo.ConsumeMFAChallenge(claims.ID)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+	if !redeemed {
+		return nil, models.ErrInvalidToken
+	}
+
+	ok, err := s.checkTOTP(user, req.Code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		ok, err = s.userRepo.ConsumeRecoveryCode(claims.Subject, tokens.HashRecoveryCode(req.Code))
+		if err != nil {
+			return nil, errors.New("database error")
+		}
+		if ok {
+			log.Printf("User %s logged in with a recovery code", claims.Subject)
+		}
+	}
+	if !ok {
+		s.recordLoginFailure(user.Email, req.IPAddress)
+		// Nothing was used up, so hand the challenge back and let a mistyped code be retried
+		if ttl := time.Until(claims.ExpiresAt.Time); ttl > 0 {
+			if err := s.userRepo.StoreMFAChallenge(claims.ID, ttl); err != nil {
+				log.Printf("Failed to restore MFA challenge for user %s: %v", claims.Subject, err)
+			}
+		}
+		return nil, models.ErrInvalidMFACode
+	}
+
+	return s.startSession(user, req.IPAddress, req.UserAgent, true)
+}
+
+func (s *UserService) issueMFAChallenge(user *models.User) (*models.MFAChallenge, error) {
+	token, tokenID, err := s.tokenIssuer.IssueMFAChallenge(strconv.Itoa(user.ID), mfaChallengeTTL)
+	if err != nil {
+		return nil, errors.New("error creating session")
+	}
+	if err := s.userRepo.StoreMFAChallenge(tokenID, mfaChallengeTTL); err != nil {
+		return nil, errors.New("error creating session")
+	}
+
+	return &models.MFAChallenge{
+		Token:     token,
+		ExpiresIn: int(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+// checkTOTP validates a TOTP code for the user's secret. Each code is only
+// accepted once, even though it stays valid for its whole time step.
+func (s *UserService) checkTOTP(user *models.User, code string) (bool, error) {
+	step, ok := tokens.ValidateTOTP(user.MFASecret, strings.TrimSpace(code), time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	fresh, err := s.userRepo.MarkTOTPStepUsed(strconv.Itoa(user.ID), step, tokens.TOTPStepTTL())
+	if err != nil {
+		return false, errors.New("database error")
+	}
+	return fresh, nil
+}
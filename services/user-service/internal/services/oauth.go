@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lucas/user-service/internal/models"
+	"github.com/lucas/user-service/internal/oidc"
+	"github.com/lucas/user-service/internal/tokens"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// oauthStateTTL is how long the user has to complete a sign-in at the provider
+const oauthStateTTL = 10 * time.Minute
+
+// StartOAuthLogin begins a sign-in with an external identity provider and
+// returns the URL to send the user to.
+func (s *UserService) StartOAuthLogin(providerName string) (string, error) {
+	return s.startOAuth(providerName, "")
+}
+
+// StartIdentityLink begins linking an external identity to userID once the
+// password confirms it is them, and returns the URL to send the user to. The
+// provider redirects back to the sign-in callback as for StartOAuthLogin.
+// This is how an identity reaches an account whose email isn't verified.
+func (s *UserService) StartIdentityLink(userID, providerName, password string) (string, error) {
+	if _, ok := s.config.IdentityProviders[providerName]; !ok {
+		return "", models.ErrUnknownIdentityProvider
+	}
+
+	user, err := s.GetProfile(userID)
+	if err != nil {
+		return "", err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return "", models.ErrInvalidCredentials
+	}
+
+	return s.startOAuth(providerName, userID)
+}
+
+func (s *UserService) startOAuth(providerName, linkUserID string) (string, error) {
+	provider, ok := s.config.IdentityProviders[providerName]
+	if !ok {
+		return "", models.ErrUnknownIdentityProvider
+	}
+
+	state, _, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return "", errors.New("error starting oauth login")
+	}
+	verifier, err := oidc.NewPKCEVerifier()
+	if err != nil {
+		return "", errors.New("error starting oauth login")
+	}
+
+	if err := s.userRepo.StoreOAuthState(state, providerName, verifier, linkUserID, oauthStateTTL); err != nil {
+		return "", errors.New("error starting oauth login")
+	}
+
+	return provider.AuthCodeURL(state, verifier), nil
+}
+
+// CompleteOAuthLogin finishes a sign-in when the provider redirects back. The
+// identity is linked to the user who started a link with StartIdentityLink,
+// else matched to an already linked user, else linked to the account with
+// the same email when both the provider and the account verified that email,
+// else a new account is created.
+func (s *UserService) CompleteOAuthLogin(req *models.OAuthCallbackRequest) (*models.LoginResult, error) {
+	providerName, verifier, linkUserID, err := s.userRepo.ConsumeOAuthState(req.State)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidOAuthState) {
+			return nil, err
+		}
+		return nil, errors.New("database error")
+	}
+	provider, ok := s.config.IdentityProviders[providerName]
+	if !ok || providerName != req.Provider {
+		return nil, models.ErrInvalidOAuthState
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	accessToken, err := provider.Exchange(ctx, req.Code, verifier)
+	if err != nil {
+		log.Printf("OAuth code exchange with %s failed: %v", providerName, err)
+		return nil, models.ErrOAuthFailed
+	}
+	info, err := provider.UserInfo(ctx, accessToken)
+	if err != nil {
+		log.Printf("OAuth userinfo from %s failed: %v", providerName, err)
+		return nil, models.ErrOAuthFailed
+	}
+
+	var user *models.User
+	if linkUserID != "" {
+		user, err = s.linkExternalUser(linkUserID, providerName, info)
+	} else {
+		user, err = s.resolveExternalUser(providerName, info)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if s.config.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return nil, models.ErrEmailNotVerified
+	}
+
+	// The provider replaces the password, not the second factor
+	if user.MFAEnabledAt != nil {
+		challenge, err := s.issueMFAChallenge(user)
+		if err != nil {
+			return nil, err
+		}
+		return &models.LoginResult{MFAChallenge: challenge}, nil
+	}
+
+	return s.startSession(user, req.IPAddress, req.UserAgent, false)
+}
+
+func (s *UserService) resolveExternalUser(providerName string, info *oidc.UserInfo) (*models.User, error) {
+	user, err := s.userRepo.GetUserByIdentity(providerName, info.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, models.ErrUserNotFound) {
+		return nil, errors.New("database error")
+	}
+
+	email := strings.TrimSpace(info.Email)
+	if email == "" || !s.isValidEmail(email) {
+		return nil, models.ErrNoUsableEmail
+	}
+
+	// Linking on an unverified email would let anyone claim an account: the
+	// provider's, by signing in as the account, or the account's, by
+	// registering the email with a password before its owner signs in. Such
+	// accounts link from StartIdentityLink, with their password
+	existing, err := s.userRepo.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		return nil, errors.New("database error")
+	}
+	if existing != nil {
+		if !info.EmailVerified || existing.EmailVerifiedAt == nil {
+			return nil, models.ErrEmailInUse
+		}
+		if err := s.userRepo.LinkIdentity(existing.ID, providerName, info.Subject, email); err != nil {
+			return nil, errors.New("database error")
+		}
+		log.Printf("Linked %s identity to user %d", providerName, existing.ID)
+		return existing, nil
+	}
+
+	name := strings.TrimSpace(info.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+
+	// A random password nobody knows; a password can be set with the reset flow
+	unusable, _, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return nil, errors.New("error creating user")
+	}
+	hash, err := s.hashPassword(unusable)
+	if err != nil {
+		return nil, errors.New("error hashing password")
+	}
+
+	user, err = s.userRepo.CreateExternalUser(email, name, hash, info.EmailVerified, providerName, info.Subject)
+	if err != nil {
+		if errors.Is(err, models.ErrEmailInUse) {
+			return nil, err
+		}
+		return nil, errors.New("error creating user")
+	}
+	log.Printf("Created user %d from %s identity", user.ID, providerName)
+	return user, nil
+}
+
+// linkExternalUser links an identity to the user who started the link.
+func (s *UserService) linkExternalUser(userID, providerName string, info *oidc.UserInfo) (*models.User, error) {
+	user, err := s.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.userRepo.LinkIdentity(user.ID, providerName, info.Subject, strings.TrimSpace(info.Email)); err != nil {
+		if errors.Is(err, models.ErrIdentityAlreadyLinked) {
+			return nil, err
+		}
+		return nil, errors.New("database error")
+	}
+	log.Printf("Linked %s identity to user %d on their request", providerName, user.ID)
+	return user, nil
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	sharedmodels "github.com/lucas/shared/models"
+	"github.com/lucas/user-service/internal/models"
+	"github.com/lucas/user-service/internal/repository"
+	"github.com/lucas/user-service/internal/tokens"
+)
+
+// passwordResetTTL is how long a password reset link stays valid
+const passwordResetTTL = time.Hour
+
+var (
+	// Per email: enough for a lost email or two, not for flooding an inbox
+	resetEmailThrottle = loginThrottle{freeAttempts: 3, baseDelay: 5 * time.Minute, lockoutAfter: 5, lockoutDuration: time.Hour}
+	// Per client IP: keeps one client from spraying emails and outbox rows
+	resetIPThrottle = loginThrottle{freeAttempts: 10, baseDelay: time.Minute, lockoutAfter: 30, lockoutDuration: time.Hour}
+)
+
+// ForgotPassword emails a password reset link when email belongs to an
+// account. It succeeds either way and sends the link in the background, so
+// neither the answer nor its timing reveals whether the email is registered.
+// Shutdown waits for the link to be sent, see Wait.
+// Requests are throttled per email and per client IP ip, unknown emails
+// included.
+func (s *UserService) ForgotPassword(email, ip string) error {
+	if !s.isValidEmail(email) {
+		return models.ErrInvalidEmail
+	}
+	if err := s.throttlePasswordReset(email, ip); err != nil {
+		return err
+	}
+
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+
+		user, err := s.userRepo.GetUserByEmail(email)
+		if err != nil {
+			if !errors.Is(err, models.ErrUserNotFound) {
+				log.Printf("Failed to look up user for password reset: %v", err)
+			}
+			return
+		}
+
+		if err := s.sendPasswordReset(user); err != nil {
+			log.Printf("Failed to send password reset for user %d: %v", user.ID, err)
+		}
+	}()
+
+	return nil
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword. The
+// token works once and every existing session of the user is revoked.
+func (s *UserService) ResetPassword(token string, newPassword string) error {
+	if !s.isValidPassword(newPassword) {
+		return models.ErrInvalidPassword
+	}
+
+	hash, err := s.hashPassword(newPassword)
+	if err != nil {
+		return errors.New("error hashing password")
+	}
+
+	userID, err := s.userRepo.ResetPassword(tokens.HashOpaqueToken(token), hash)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			return err
+		}
+		return errors.New("database error")
+	}
+
+	if err := s.userRepo.RevokeSessionsUntil(userID, time.Now(), s.config.SessionTTL); err != nil {
+		return errors.New("error revoking sessions")
+	}
+
+	return nil
+}
+
+func (s *UserService) sendPasswordReset(user *models.User) error {
+	token, hash, err := tokens.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if err := s.userRepo.CreatePasswordResetToken(user.ID, hash, passwordResetTTL); err != nil {
+		return err
+	}
+
+	return s.events.Publish(sharedmodels.EventPasswordResetRequested, strconv.Itoa(user.ID), sharedmodels.TokenEventData{
+		Email:     user.Email,
+		Name:      user.Name,
+		Token:     token,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+}
+
+// throttlePasswordReset fails while email or ip is locked from requesting
+// password resets, and otherwise counts the request against both.
+func (s *UserService) throttlePasswordReset(email, ip string) error {
+	keys := map[string]string{repository.LoginScopeResetEmail: normalizeEmail(email)}
+	if ip != "" {
+		keys[repository.LoginScopeResetIP] = ip
+	}
+
+	locked, err := s.isThrottled(keys)
+	if err != nil {
+		return errors.New("database error")
+	}
+	if locked {
+		return models.ErrTooManyPasswordResets
+	}
+
+	for scope, key := range keys {
+		throttle := resetEmailThrottle
+		if scope == repository.LoginScopeResetIP {
+			throttle = resetIPThrottle
+		}
+		s.countThrottled(scope, key, throttle)
+	}
+	return nil
+}
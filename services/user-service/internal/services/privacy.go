@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	sharedmodels "github.com/lucas/shared/models"
+	"github.com/lucas/user-service/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// exportTimeout bounds how long an export waits for the other services
+const exportTimeout = 10 * time.Second
+
+// ExportData assembles everything held about the user: what user-service
+// stores itself plus the fragments the other services send back. Services that
+// don't answer in time are listed under missing_services.
+func (s *UserService) ExportData(ctx context.Context, userID string) (map[string]any, error) {
+	user, err := s.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.ListSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	apiKeys, err := s.ListAPIKeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := s.userRepo.ListIdentities(userID)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+
+	archive := map[string]any{
+		"generated_at": time.Now().UTC(),
+		"user":         user,
+		"sessions":     sessions,
+		"api_keys":     apiKeys,
+		"identities":   identities,
+	}
+
+	if s.config.Exports != nil && len(s.config.ExportServices) > 0 {
+		fragments, missing, err := s.config.Exports.Collect(ctx, userID, s.config.ExportServices, exportTimeout)
+		if err != nil {
+			log.Printf("Failed to collect data export of user %s: %v", userID, err)
+			return nil, errors.New("error exporting data")
+		}
+		archive["services"] = fragments
+		if len(missing) > 0 {
+			archive["missing_services"] = missing
+		}
+	}
+
+	return archive, nil
+}
+
+// DeleteAccount deletes the user after checking their password, ends all of
+// their sessions and publishes user.deleted so every service purges its data.
+func (s *UserService) DeleteAccount(userID string, password string) error {
+	user, err := s.GetProfile(userID)
+	if err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return models.ErrInvalidCredentials
+	}
+
+	// Queued with the deletion, so other services purge exactly the users deleted here
+	deleted, err := s.events.Event(sharedmodels.EventUserDeleted, userID, sharedmodels.UserDeletedData{})
+	if err != nil {
+		return errors.New("error deleting user")
+	}
+	if err := s.userRepo.DeleteUser(userID, deleted); err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return err
+		}
+		return errors.New("database error")
+	}
+
+	// The account is gone either way, so a cleanup failure is only logged
+	if err := s.endAllSessions(userID); err != nil {
+		log.Printf("Failed to end sessions of deleted user %s: %v", userID, err)
+	}
+
+	return nil
+}
@@ -2,41 +2,81 @@ package services
 
 import (
 	"errors"
+	"log"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	sharedmodels "github.com/lucas/shared/models"
+	"github.com/lucas/shared/outbox"
+	"github.com/lucas/user-service/internal/events"
+	"github.com/lucas/user-service/internal/export"
 	"github.com/lucas/user-service/internal/models"
+	"github.com/lucas/user-service/internal/oidc"
 	"github.com/lucas/user-service/internal/repository"
+	"github.com/lucas/user-service/internal/tokens"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// dummyPasswordHash is checked against when the email is unknown so that
+// path costs the same bcrypt work as a wrong password
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password for unknown users"), 12)
+
+type Config struct {
+	SessionTTL           time.Duration // How long a refresh token family stays valid
+	RequireVerifiedEmail bool          // Refuse logins until the email address is verified
+	MFAIssuer            string        // Account issuer shown by authenticator apps
+	IdentityProviders    map[string]*oidc.Provider
+	Exports              *export.Collector // Gathers the other services' part of data exports
+	ExportServices       []string          // Services expected to answer data export requests
+}
+
 type UserService struct {
-	userRepo *repository.UserRepository
+	userRepo    *repository.UserRepository
+	tokenIssuer *tokens.TokenIssuer
+	events      *events.Publisher
+	config      Config
+
+	// Work requests leave running after answering, e.g. password reset emails
+	pending sync.WaitGroup
 }
 
-func NewUserService(userRepo *repository.UserRepository) *UserService {
+func NewUserService(userRepo *repository.UserRepository, tokenIssuer *tokens.TokenIssuer, events *events.Publisher, config Config) *UserService {
 	return &UserService{
-		userRepo: userRepo,
+		userRepo:    userRepo,
+		tokenIssuer: tokenIssuer,
+		events:      events,
+		config:      config,
 	}
 }
 
+// Wait blocks until work that requests left running in the background is
+// done, so a shutdown doesn't drop it.
+func (s *UserService) Wait() {
+	s.pending.Wait()
+}
+
 func (s *UserService) RegisterUser(req *models.RegisterUserRequest) (*models.User, error) {
 
 	// Validate email
 	if !s.isValidEmail(req.Email) {
-		return nil, errors.New("invalid email format")
+		return nil, models.ErrInvalidEmail
 	}
 
 	// Validade password
 	if !s.isValidPassword(req.Password) {
-		return nil, errors.New("invalid password format")
+		return nil, models.ErrInvalidPassword
 	}
 
 	// Verify is email is in use
 	existingUser, err := s.userRepo.GetUserByEmail(req.Email)
-	if err != nil && err.Error() != "user not found" {
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
 		return nil, errors.New("database error")
 	}
 	if existingUser != nil {
-		return nil, errors.New("email already in use")
+		return nil, models.ErrEmailInUse
 	}
 
 	// Hash password
@@ -45,9 +85,21 @@ func (s *UserService) RegisterUser(req *models.RegisterUserRequest) (*models.Use
 		return nil, errors.New("error hashing password")
 	}
 
-	// Create user in the database
-	user, err := s.userRepo.CreateUser(req, string(hash))
+	// Create user in the database, along with the event asking
+	// notification-service to deliver the verification link
+	user, err := s.userRepo.CreateUser(req, string(hash), func(user *models.User) ([]outbox.Message, error) {
+		message, err := s.verificationEvent(user, user.Email, sharedmodels.EventUserRegistered)
+		if err != nil {
+			return nil, err
+		}
+		return []outbox.Message{message}, nil
+	})
+	if errors.Is(err, models.ErrEmailInUse) {
+		// A concurrent registration claimed the email after the check above
+		return nil, err
+	}
 	if err != nil {
+		log.Printf("Failed to create user %s: %v", req.Email, err)
 		return nil, errors.New("error creating user")
 	}
 
@@ -55,44 +107,380 @@ func (s *UserService) RegisterUser(req *models.RegisterUserRequest) (*models.Use
 	return user, nil
 }
 
-func (s *UserService) LoginUser(req *models.LoginUserRequest) (*models.Session, string, error) {
+// LoginUser checks the credentials and starts a session. Accounts with MFA
+// enabled get a challenge instead, completed with VerifyMFA.
+func (s *UserService) LoginUser(req *models.LoginUserRequest) (*models.LoginResult, error) {
 
 	// Validate email
 	if !s.isValidEmail(req.Email) {
-		return nil, "", errors.New("invalid email format")
+		return nil, models.ErrInvalidEmail
 	}
 
 	// Validade password
 	if !s.isValidPassword(req.Password) {
-		return nil, "", errors.New("invalid password format")
+		return nil, models.ErrInvalidPassword
+	}
+
+	// Refuse locked out accounts and IPs before spending any bcrypt work
+	if err := s.checkLoginAllowed(req.Email, req.IPAddress); err != nil {
+		return nil, err
 	}
 
 	// Verify email and get user
 	user, err := s.userRepo.GetUserByEmail(req.Email)
 	if err != nil {
-		if err.Error() == "user not found" {
-			return nil, "", errors.New("invalid credentials")
+		if !errors.Is(err, models.ErrUserNotFound) {
+			return nil, errors.New("database error")
 		}
-		return nil, "", errors.New("database error")
+		// Unknown emails go through the same work as a wrong password
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(req.Password))
+		s.recordLoginFailure(req.Email, req.IPAddress)
+		return nil, models.ErrInvalidCredentials
 	}
 
 	// Verify password
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 	if err != nil {
-		return nil, "", errors.New("invalid credentials")
+		s.recordLoginFailure(req.Email, req.IPAddress)
+		return nil, models.ErrInvalidCredentials
+	}
+
+	// Only checked once the password matched, so it doesn't reveal registered emails
+	if s.config.RequireVerifiedEmail && user.EmailVerifiedAt == nil {
+		return nil, models.ErrEmailNotVerified
+	}
+
+	// The second factor is still missing, the failure counter stays until it's verified
+	if user.MFAEnabledAt != nil {
+		challenge, err := s.issueMFAChallenge(user)
+		if err != nil {
+			return nil, err
+		}
+		return &models.LoginResult{MFAChallenge: challenge}, nil
+	}
+
+	return s.startSession(user, req.IPAddress, req.UserAgent, false)
+}
+
+// startSession creates a session holding the first refresh token of its
+// family and signs an access token bound to it. A successful login also
+// clears the account's failed login counter. Disabled accounts can't sign in.
+func (s *UserService) startSession(user *models.User, ipAddress, userAgent string, mfa bool) (*models.LoginResult, error) {
+	if user.DisabledAt != nil {
+		return nil, models.ErrAccountDisabled
+	}
+
+	// The IP counter is left alone, one valid account must not reset it
+	if err := s.userRepo.ClearLoginFailures(repository.LoginScopeEmail, normalizeEmail(user.Email)); err != nil {
+		log.Printf("Failed to clear login failures for user %d: %v", user.ID, err)
+	}
+
+	sessionID, err := repository.NewSessionID()
+	if err != nil {
+		return nil, errors.New("error creating session")
+	}
+	refreshToken, refreshHash, err := tokens.NewRefreshToken(sessionID)
+	if err != nil {
+		return nil, errors.New("error creating session")
+	}
+	session, err := s.userRepo.CreateSession(sessionID, user, refreshHash, s.config.SessionTTL, ipAddress, userAgent, mfa)
+	if err != nil {
+		return nil, errors.New("error creating session")
+	}
+
+	tokenPair, err := s.issueTokenPair(user, session, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.LoginResult{Session: session, Tokens: tokenPair}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once: presenting one that was
+// already rotated means it leaked, so the whole session is revoked.
+func (s *UserService) RefreshSession(refreshToken string) (*models.Session, *models.TokenPair, error) {
+	sessionID, presentedHash, err := tokens.ParseRefreshToken(refreshToken)
+	if err != nil {
+		return nil, nil, models.ErrInvalidRefreshToken
+	}
+
+	newRefreshToken, newHash, err := tokens.NewRefreshToken(sessionID)
+	if err != nil {
+		return nil, nil, errors.New("error refreshing session")
+	}
+
+	result, err := s.userRepo.RotateRefreshToken(sessionID, presentedHash, newHash)
+	if err != nil {
+		return nil, nil, errors.New("error refreshing session")
+	}
+
+	switch result {
+	case repository.RefreshReused:
+		log.Printf("Refresh token reuse detected, revoking session %s", sessionID)
+		if err := s.userRepo.DeleteSession(sessionID); err != nil {
+			log.Printf("Failed to revoke session %s: %v", sessionID, err)
+		}
+		return nil, nil, models.ErrRefreshTokenReused
+	case repository.RefreshInvalid:
+		return nil, nil, models.ErrInvalidRefreshToken
+	}
+
+	session, err := s.userRepo.GetSession(sessionID)
+	if err != nil {
+		return nil, nil, models.ErrInvalidRefreshToken
+	}
+
+	// Sessions older than a "log out of all devices" can't be refreshed
+	revokedUntil, err := s.userRepo.GetSessionsRevokedUntil(session.UserID)
+	if err != nil {
+		return nil, nil, errors.New("error refreshing session")
+	}
+	if !revokedUntil.IsZero() && session.CreatedAt.UnixMilli() < revokedUntil.UnixMilli() {
+		if err := s.userRepo.DeleteSession(sessionID); err != nil {
+			log.Printf("Failed to delete revoked session %s: %v", sessionID, err)
+		}
+		return nil, nil, models.ErrInvalidRefreshToken
+	}
+
+	user, err := s.userRepo.GetUserByID(session.UserID)
+	if err != nil {
+		return nil, nil, models.ErrInvalidRefreshToken
+	}
+
+	tokenPair, err := s.issueTokenPair(user, session, newRefreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return session, tokenPair, nil
+}
+
+// LogoutUser ends the caller's session and revokes the access token used for
+// the request. With AllDevices every other session of the user is revoked too.
+func (s *UserService) LogoutUser(req *models.LogoutUserRequest) error {
+	if req.UserID == "" || req.TokenID == "" {
+		return models.ErrInvalidLogoutRequest
+	}
+
+	if req.SessionID != "" {
+		session, err := s.userRepo.GetSession(req.SessionID)
+		if err == nil && session.UserID == req.UserID {
+			if err := s.userRepo.DeleteSession(req.SessionID); err != nil {
+				return errors.New("error deleting session")
+			}
+		}
+	}
+
+	if err := s.userRepo.BlacklistToken(req.TokenID, req.TokenExpiresAt); err != nil {
+		return errors.New("error revoking token")
+	}
+
+	if req.AllDevices {
+		if err := s.userRepo.RevokeSessionsUntil(req.UserID, time.Now(), s.config.SessionTTL); err != nil {
+			return errors.New("error revoking sessions")
+		}
+	}
+
+	return nil
+}
+
+// ListSessions returns the user's active sessions. Sessions revoked by a
+// "log out of all devices" are left out even before they expire.
+func (s *UserService) ListSessions(userID string) ([]*models.Session, error) {
+	sessions, err := s.userRepo.ListSessions(userID)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+
+	revokedUntil, err := s.userRepo.GetSessionsRevokedUntil(userID)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+	if revokedUntil.IsZero() {
+		return sessions, nil
+	}
+
+	active := make([]*models.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if session.CreatedAt.UnixMilli() >= revokedUntil.UnixMilli() {
+			active = append(active, session)
+		}
+	}
+	return active, nil
+}
+
+// RevokeSession ends one of the user's sessions, e.g. on a lost or stolen
+// device. Its refresh token stops working and the gateway refuses its access tokens.
+func (s *UserService) RevokeSession(userID string, sessionID string) error {
+	session, err := s.userRepo.GetSession(sessionID)
+	if err != nil {
+		if errors.Is(err, models.ErrSessionNotFound) {
+			return err
+		}
+		return errors.New("database error")
+	}
+	// Someone else's session is reported as missing rather than forbidden
+	if session.UserID != userID {
+		return models.ErrSessionNotFound
 	}
 
-	// Create new session in redis
-	session, token, err := s.userRepo.CreateSession(user)
+	if err := s.userRepo.DeleteSession(sessionID); err != nil {
+		return errors.New("error deleting session")
+	}
+	return nil
+}
+
+func (s *UserService) GetProfile(userID string) (*models.User, error) {
+	user, err := s.userRepo.GetUserByID(userID)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil, err
+		}
+		return nil, errors.New("database error")
+	}
+
+	roles, _, err := s.userRepo.GetUserAccess(userID)
 	if err != nil {
-		return nil, "", errors.New("error creating session")
+		return nil, errors.New("database error")
 	}
+	user.Roles = roles
 
-	// Return the created session and token
-	return session, token, nil
+	return user, nil
 }
 
+// UpdateProfile applies name changes immediately. A new email only becomes
+// pending: it replaces the current one once confirmed through the token sent
+// to the new address.
+func (s *UserService) UpdateProfile(req *models.UpdateProfileRequest) (*models.User, error) {
+	user, err := s.GetProfile(req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != nil && *req.Name != user.Name {
+		if strings.TrimSpace(*req.Name) == "" {
+			return nil, models.ErrEmptyName
+		}
+		user, err = s.userRepo.UpdateName(req.UserID, strings.TrimSpace(*req.Name))
+		if err != nil {
+			return nil, errors.New("database error")
+		}
+	}
+
+	if req.Email != nil && *req.Email != user.Email {
+		user, err = s.requestEmailChange(user, *req.Email)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+func (s *UserService) requestEmailChange(user *models.User, email string) (*models.User, error) {
+	if !s.isValidEmail(email) {
+		return nil, models.ErrInvalidEmail
+	}
+
+	existingUser, err := s.userRepo.GetUserByEmail(email)
+	if err != nil && !errors.Is(err, models.ErrUserNotFound) {
+		return nil, errors.New("database error")
+	}
+	if existingUser != nil {
+		return nil, models.ErrEmailInUse
+	}
+
+	user, err = s.userRepo.SetPendingEmail(strconv.Itoa(user.ID), email)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+
+	// The new address only replaces the current one once verified
+	if err := s.sendVerification(user, email, sharedmodels.EventEmailChangeRequested); err != nil {
+		log.Printf("Failed to send email change confirmation for user %d: %v", user.ID, err)
+		return nil, errors.New("error sending confirmation")
+	}
+
+	return user, nil
+}
+
+// ChangePassword replaces the password after checking the current one. All
+// sessions are revoked so a stolen session doesn't survive the change.
+func (s *UserService) ChangePassword(req *models.ChangePasswordRequest) error {
+	user, err := s.GetProfile(req.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		return models.ErrInvalidCredentials
+	}
+
+	if !s.isValidPassword(req.NewPassword) {
+		return models.ErrInvalidPassword
+	}
+
+	hash, err := s.hashPassword(req.NewPassword)
+	if err != nil {
+		return errors.New("error hashing password")
+	}
+
+	if err := s.userRepo.UpdatePassword(req.UserID, hash); err != nil {
+		return errors.New("database error")
+	}
+
+	if err := s.userRepo.RevokeSessionsUntil(req.UserID, time.Now(), s.config.SessionTTL); err != nil {
+		return errors.New("error revoking sessions")
+	}
+
+	return nil
+}
+
+func (s *UserService) issueTokenPair(user *models.User, session *models.Session, refreshToken string) (*models.TokenPair, error) {
+	// Roles are read on every issue so role changes apply from the next refresh
+	roles, permissions, err := s.userRepo.GetUserAccess(session.UserID)
+	if err != nil {
+		return nil, errors.New("error issuing token")
+	}
+
+	accessToken, expiresAt, err := s.tokenIssuer.IssueAccessToken(user, session, roles, permissions)
+	if err != nil {
+		return nil, errors.New("error issuing token")
+	}
+
+	return &models.TokenPair{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(time.Until(expiresAt).Seconds()),
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// isValidEmail accepts a bare RFC 5322 address (no display name) whose domain has at least one dot.
 func (s *UserService) isValidEmail(email string) bool {
+	if len(email) > 254 {
+		return false
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return false
+	}
+
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || len(local) > 64 {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+	}
 	return true
 }
 
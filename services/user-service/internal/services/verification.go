@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	sharedmodels "github.com/lucas/shared/models"
+	"github.com/lucas/shared/outbox"
+	"github.com/lucas/user-service/internal/models"
+)
+
+// verificationTTL is how long an email verification link stays valid
+const verificationTTL = 24 * time.Hour
+
+// VerifyEmail redeems a verification token. It either confirms the account's
+// current address or completes a pending email change, depending on which
+// address the token was issued for.
+func (s *UserService) VerifyEmail(token string) (*models.User, error) {
+	claims, err := s.tokenIssuer.ParseVerificationToken(token)
+	if err != nil {
+		return nil, models.ErrInvalidToken
+	}
+
+	redeemed, err := s.userRepo.ConsumeVerificationToken(claims.ID)
+	if err != nil {
+		return nil, errors.New("database error")
+	}
+	if !redeemed {
+		return nil, models.ErrInvalidToken
+	}
+
+	user, err := s.userRepo.GetUserByID(claims.Subject)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil, models.ErrInvalidToken
+		}
+		return nil, errors.New("database error")
+	}
+
+	switch claims.Email {
+	case user.PendingEmail:
+		user, err = s.userRepo.ConfirmPendingEmail(claims.Subject, claims.Email)
+		if err != nil {
+			if errors.Is(err, models.ErrEmailInUse) {
+				return nil, err
+			}
+			return nil, errors.New("database error")
+		}
+	case user.Email:
+		user, err = s.userRepo.MarkEmailVerified(claims.Subject, claims.Email)
+		if err != nil {
+			return nil, errors.New("database error")
+		}
+	default:
+		// The address changed again since this token was sent
+		return nil, models.ErrInvalidToken
+	}
+
+	return user, nil
+}
+
+// ResendVerification sends a fresh verification link to an unverified
+// account. It never reports whether the email is registered.
+func (s *UserService) ResendVerification(email string) error {
+	user, err := s.userRepo.GetUserByEmail(email)
+	if err != nil {
+		if errors.Is(err, models.ErrUserNotFound) {
+			return nil
+		}
+		return errors.New("database error")
+	}
+
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+
+	if err := s.sendVerification(user, user.Email, sharedmodels.EventUserRegistered); err != nil {
+		log.Printf("Failed to resend verification email for user %d: %v", user.ID, err)
+		return errors.New("error sending confirmation")
+	}
+	return nil
+}
+
+// sendVerification issues a single-use token proving ownership of email and
+// publishes eventType so notification-service mails it to that address.
+func (s *UserService) sendVerification(user *models.User, email string, eventType string) error {
+	message, err := s.verificationEvent(user, email, eventType)
+	if err != nil {
+		return err
+	}
+	return s.events.Enqueue(message)
+}
+
+// verificationEvent issues a single-use token proving ownership of email and
+// returns the eventType event carrying it.
+func (s *UserService) verificationEvent(user *models.User, email string, eventType string) (outbox.Message, error) {
+	userID := strconv.Itoa(user.ID)
+
+	token, tokenID, err := s.tokenIssuer.IssueVerificationToken(userID, email, verificationTTL)
+	if err != nil {
+		return outbox.Message{}, err
+	}
+	if err := s.userRepo.StoreVerificationToken(tokenID, verificationTTL); err != nil {
+		return outbox.Message{}, err
+	}
+
+	return s.events.Event(eventType, userID, sharedmodels.TokenEventData{
+		Email:     email,
+		Name:      user.Name,
+		Token:     token,
+		ExpiresAt: time.Now().Add(verificationTTL),
+	})
+}
@@ -0,0 +1,40 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// apiKeyPrefix makes keys recognisable, e.g. by secret scanners
+const apiKeyPrefix = "mck"
+
+var ErrMalformedAPIKey = errors.New("malformed api key")
+
+// NewAPIKey creates an API key "mck_<prefix>_<secret>". The prefix is stored
+// in clear to find the key and to show it in listings; only the hash of the
+// secret is stored.
+func NewAPIKey() (key string, prefix string, hash string, err error) {
+	raw := make([]byte, 4)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	prefix = hex.EncodeToString(raw)
+
+	secret, hash, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	return apiKeyPrefix + "_" + prefix + "_" + secret, prefix, hash, nil
+}
+
+// ParseAPIKey splits an API key into its prefix and secret hash.
+func ParseAPIKey(key string) (prefix string, hash string, err error) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", "", ErrMalformedAPIKey
+	}
+	return parts[1], HashOpaqueToken(parts[2]), nil
+}
@@ -0,0 +1,67 @@
+package tokens
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseAPIKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		prefix string
+		secret string // Empty when the key is malformed
+	}{
+		{"valid", "mck_0a1b2c3d_s3cret", "0a1b2c3d", "s3cret"},
+		{"underscore in secret", "mck_0a1b2c3d_s3_cr_et", "0a1b2c3d", "s3_cr_et"},
+		{"empty", "", "", ""},
+		{"wrong scheme", "pk_0a1b2c3d_s3cret", "", ""},
+		{"uppercase scheme", "MCK_0a1b2c3d_s3cret", "", ""},
+		{"no secret", "mck_0a1b2c3d", "", ""},
+		{"empty secret", "mck_0a1b2c3d_", "", ""},
+		{"empty prefix", "mck__s3cret", "", ""},
+		{"scheme only", "mck", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, hash, err := ParseAPIKey(tt.key)
+			if tt.secret == "" {
+				if !errors.Is(err, ErrMalformedAPIKey) {
+					t.Fatalf("ParseAPIKey(%q) = %v, want ErrMalformedAPIKey", tt.key, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAPIKey(%q) = %v", tt.key, err)
+			}
+			if prefix != tt.prefix {
+				t.Errorf("prefix = %q, want %q", prefix, tt.prefix)
+			}
+			if hash != HashOpaqueToken(tt.secret) {
+				t.Errorf("hash isn't the hash of secret %q", tt.secret)
+			}
+		})
+	}
+}
+
+func TestNewAPIKeyParsesBack(t *testing.T) {
+	// Secrets are base64url and often contain underscores themselves
+	for i := 0; i < 100; i++ {
+		key, prefix, hash, err := NewAPIKey()
+		if err != nil {
+			t.Fatalf("NewAPIKey() = %v", err)
+		}
+		if !strings.HasPrefix(key, "mck_"+prefix+"_") {
+			t.Fatalf("key %q doesn't start with mck_%s_", key, prefix)
+		}
+
+		parsedPrefix, parsedHash, err := ParseAPIKey(key)
+		if err != nil {
+			t.Fatalf("ParseAPIKey(%q) = %v", key, err)
+		}
+		if parsedPrefix != prefix || parsedHash != hash {
+			t.Fatalf("ParseAPIKey(%q) = %q, %q, want %q, %q", key, parsedPrefix, parsedHash, prefix, hash)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package tokens
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/lucas/shared/jwks"
+)
+
+// signingKey is a private key able to sign tokens under its kid.
+type signingKey struct {
+	kid     string
+	private crypto.Signer
+	method  jwt.SigningMethod
+}
+
+// KeyManager holds the token signing keys. Exactly one key is active and used
+// for new tokens; the others stay published in the JWKS so tokens they signed
+// keep verifying until they expire. Rotating means adding a new key file,
+// pointing the active kid at it and removing the old file once its tokens are gone.
+type KeyManager struct {
+	dir       string
+	activeKid string
+
+	mu     sync.RWMutex
+	keys   map[string]*signingKey
+	active *signingKey
+}
+
+// NewKeyManager loads every <kid>.pem PKCS#8 private key (RSA or Ed25519) from dir.
+// activeKid selects the signing key; when empty the last kid in lexical order is
+// used, so date-prefixed file names rotate naturally. Without a directory an
+// ephemeral Ed25519 key is generated, which is only suitable for a single replica.
+func NewKeyManager(dir, activeKid string) (*KeyManager, error) {
+	m := &KeyManager{
+		dir:       dir,
+		activeKid: activeKid,
+	}
+
+	if dir == "" {
+		if err := m.generateEphemeralKey(); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Reload re-reads the key directory, picking up added, removed or re-activated keys.
+func (m *KeyManager) Reload() error {
+	if m.dir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(m.dir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("failed to list signing keys: %w", err)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no signing keys found in %s", m.dir)
+	}
+
+	keys := make(map[string]*signingKey, len(paths))
+	kids := make([]string, 0, len(paths))
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := loadSigningKey(kid, path)
+		if err != nil {
+			return err
+		}
+		keys[kid] = key
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	activeKid := m.activeKid
+	if activeKid == "" {
+		activeKid = kids[len(kids)-1]
+	}
+	active, ok := keys[activeKid]
+	if !ok {
+		return fmt.Errorf("active signing key %q not found in %s", activeKid, m.dir)
+	}
+
+	m.mu.Lock()
+	m.keys = keys
+	m.active = active
+	m.mu.Unlock()
+
+	log.Printf("Loaded %d signing keys, active kid: %s", len(keys), activeKid)
+	return nil
+}
+
+// Watch reloads the key directory every interval until ctx is cancelled.
+func (m *KeyManager) Watch(ctx context.Context, interval time.Duration) {
+	if m.dir == "" {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Reload(); err != nil {
+				log.Printf("Failed to reload signing keys, keeping current set: %v", err)
+			}
+		}
+	}
+}
+
+// Sign signs claims with the active key and stamps its kid in the header.
+func (m *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	key := m.active
+	m.mu.RUnlock()
+
+	if key == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Parse verifies a token signed by one of our keys, selecting the key by kid.
+func (m *KeyManager) Parse(tokenString string, claims jwt.Claims, options ...jwt.ParserOption) (*jwt.Token, error) {
+	options = append(options, jwt.WithValidMethods([]string{"RS256", "EdDSA"}))
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		m.mu.RLock()
+		key, ok := m.keys[kid]
+		m.mu.RUnlock()
+
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		return key.private.Public(), nil
+	}, options...)
+}
+
+// JWKS returns the public half of every loaded key.
+func (m *KeyManager) JWKS() jwks.Set {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := jwks.Set{Keys: make([]jwks.Key, 0, len(m.keys))}
+	for kid, key := range m.keys {
+		jwk, err := jwks.NewKey(kid, key.private.Public())
+		if err != nil {
+			log.Printf("Skipping signing key %s in JWKS: %v", kid, err)
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+func (m *KeyManager) generateEphemeralKey() error {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	kid := "ephemeral-" + time.Now().UTC().Format("20060102T150405")
+	key := &signingKey{kid: kid, private: private, method: jwt.SigningMethodEdDSA}
+
+	m.keys = map[string]*signingKey{kid: key}
+	m.active = key
+
+	log.Printf("No signing key directory configured, generated ephemeral key %s", kid)
+	return nil
+}
+
+func loadSigningKey(kid, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", path)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", path, err)
+	}
+
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &signingKey{kid: kid, private: private, method: jwt.SigningMethodRS256}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{kid: kid, private: private, method: jwt.SigningMethodEdDSA}, nil
+	default:
+		return nil, fmt.Errorf("signing key %s has unsupported type %T", path, parsed)
+	}
+}
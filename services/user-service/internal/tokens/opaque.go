@@ -0,0 +1,50 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrMalformedRefreshToken = errors.New("malformed refresh token")
+
+// NewOpaqueToken creates a random single-purpose token (email confirmation,
+// refresh secret, ...). Only the returned hash should ever be stored.
+func NewOpaqueToken() (token string, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(secret)
+	return token, HashOpaqueToken(token), nil
+}
+
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewRefreshToken creates an opaque refresh token for sessionID. The token is
+// "<session id>.<secret>" so the session can be found without a lookup table;
+// only the hash of the secret is ever stored.
+func NewRefreshToken(sessionID string) (token string, hash string, err error) {
+	secret, hash, err := NewOpaqueToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	return sessionID + "." + secret, hash, nil
+}
+
+// ParseRefreshToken splits a refresh token into its session ID and secret hash.
+func ParseRefreshToken(token string) (sessionID string, hash string, err error) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return "", "", ErrMalformedRefreshToken
+	}
+	return sessionID, HashOpaqueToken(secret), nil
+}
@@ -0,0 +1,83 @@
+package tokens
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lucas/user-service/internal/models"
+)
+
+// Issue timestamps carry milliseconds so a token signed right after a
+// "log out of all devices" isn't rejected by it; see RevokeSessionsUntil.
+func init() {
+	jwt.TimePrecision = time.Millisecond
+}
+
+// Claims is the access token payload. The gateway's AuthMiddleware reads
+// user_id, jti, email and roles from it.
+type Claims struct {
+	UserID      string   `json:"user_id"`
+	SessionID   string   `json:"sid"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+	AMR         []string `json:"amr"` // Authentication methods (RFC 8176): "pwd", plus "mfa" after a second factor
+	jwt.RegisteredClaims
+}
+
+type TokenIssuer struct {
+	keys      *KeyManager
+	issuer    string
+	accessTTL time.Duration
+}
+
+func NewTokenIssuer(keys *KeyManager, issuer string, accessTTL time.Duration) *TokenIssuer {
+	return &TokenIssuer{
+		keys:      keys,
+		issuer:    issuer,
+		accessTTL: accessTTL,
+	}
+}
+
+// IssueAccessToken signs a short-lived access token for user bound to session,
+// embedding the roles and permissions the gateway authorizes routes with.
+// Every token gets its own ID so a single token can be revoked; the session ID
+// travels in the sid claim. The token never outlives its session.
+func (i *TokenIssuer) IssueAccessToken(user *models.User, session *models.Session, roles []string, permissions []string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(i.accessTTL)
+	if session.ExpiresAt.Before(expiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	amr := []string{"pwd"}
+	if session.MFA {
+		amr = append(amr, "mfa")
+	}
+
+	claims := Claims{
+		UserID:      session.UserID,
+		SessionID:   session.ID,
+		Email:       user.Email,
+		Roles:       roles,
+		Permissions: permissions,
+		AMR:         amr,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   session.UserID,
+			Issuer:    i.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	token, err := i.keys.Sign(claims)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	return token, expiresAt, nil
+}
@@ -0,0 +1,108 @@
+package tokens
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed of the RFC 6238 appendix B test vectors,
+// "12345678901234567890", base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTPMatchesRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; 6 digit codes are their last six digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		step, ok := ValidateTOTP(rfc6238Secret, tt.code, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("ValidateTOTP(%q) at %d rejected", tt.code, tt.unix)
+			continue
+		}
+		if want := tt.unix / 30; step != want {
+			t.Errorf("ValidateTOTP(%q) at %d matched step %d, want %d", tt.code, tt.unix, step, want)
+		}
+	}
+}
+
+func TestValidateTOTPSkewWindow(t *testing.T) {
+	const code = "287082" // Step 1, T = 30..59
+
+	tests := []struct {
+		name string
+		unix int64
+		ok   bool
+	}{
+		{"previous step", 0, true},
+		{"same step", 45, true},
+		{"next step", 60, true},
+		{"last second of next step", 89, true},
+		{"two steps later", 90, false},
+		{"two steps later, far", 1111111109, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(rfc6238Secret, code, time.Unix(tt.unix, 0))
+			if ok != tt.ok {
+				t.Fatalf("ValidateTOTP at %d = %t, want %t", tt.unix, ok, tt.ok)
+			}
+			if ok && step != 1 {
+				t.Errorf("ValidateTOTP at %d matched step %d, want 1", tt.unix, step)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPRejectsMalformedInput(t *testing.T) {
+	at := time.Unix(59, 0)
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+	}{
+		{"wrong code", rfc6238Secret, "287083"},
+		{"eight digits", rfc6238Secret, "94287082"},
+		{"too short", rfc6238Secret, "28708"},
+		{"empty", rfc6238Secret, ""},
+		{"invalid secret", "not base32!", "287082"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ValidateTOTP(tt.secret, tt.code, at); ok {
+				t.Errorf("ValidateTOTP(%q, %q) accepted", tt.secret, tt.code)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPIgnoresSecretCase(t *testing.T) {
+	if _, ok := ValidateTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "287082", time.Unix(59, 0)); !ok {
+		t.Error("lowercase secret rejected")
+	}
+}
+
+func TestNewTOTPSecretValidatesItsOwnCodes(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatalf("NewTOTPSecret() = %v", err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes (%v), want 20", secret, len(key), err)
+	}
+
+	now := time.Now()
+	code := hotp(key, uint64(now.Unix()/30))
+	if _, ok := ValidateTOTP(secret, code, now); !ok {
+		t.Errorf("code %q for a fresh secret rejected", code)
+	}
+}
@@ -0,0 +1,49 @@
+CREATE TABLE roles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE permissions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) UNIQUE NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE role_permissions (
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    permission_id INTEGER NOT NULL REFERENCES permissions(id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE TABLE user_roles (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role_id INTEGER NOT NULL REFERENCES roles(id) ON DELETE CASCADE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_id)
+);
+
+CREATE INDEX idx_user_roles_user_id ON user_roles(user_id);
+
+INSERT INTO roles (name, description) VALUES
+    ('customer', 'Shops and manages their own account'),
+    ('staff', 'Operates the store and supports customers'),
+    ('admin', 'Full access, including user and role management');
+
+INSERT INTO permissions (name, description) VALUES
+    ('profile:read', 'Read own profile'),
+    ('profile:write', 'Update own profile and credentials'),
+    ('users:read', 'List and view other users'),
+    ('users:write', 'Disable, enable and log out other users'),
+    ('roles:write', 'Assign roles to users'),
+    ('services:read', 'Inspect service health and internals');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE (r.name = 'customer' AND p.name IN ('profile:read', 'profile:write'))
+   OR (r.name = 'staff' AND p.name IN ('profile:read', 'profile:write', 'users:read', 'services:read'))
+   OR r.name = 'admin';
+
+-- Every existing account is a customer
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users u, roles r WHERE r.name = 'customer';
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_users_name;
+DROP TABLE IF EXISTS audit_log;
+
+ALTER TABLE users DROP COLUMN IF EXISTS disabled_at;
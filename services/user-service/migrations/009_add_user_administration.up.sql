@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP;
+
+-- Actor and target are kept as plain ids so entries outlive deleted accounts
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor_id INTEGER NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    target_user_id INTEGER,
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_audit_log_target_user_id ON audit_log(target_user_id, created_at);
+CREATE INDEX idx_users_name ON users(LOWER(name));
@@ -0,0 +1,16 @@
+-- Events waiting to be published to Kafka, written in the same transaction
+-- as the change they describe and sent by the relay in shared/outbox
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    topic VARCHAR(255) NOT NULL,
+    key TEXT NOT NULL DEFAULT '',
+    value BYTEA NOT NULL,
+    headers JSONB NOT NULL DEFAULT '{}',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP
+);
+
+CREATE INDEX idx_outbox_pending ON outbox(id) WHERE sent_at IS NULL;
+CREATE INDEX idx_outbox_sent_at ON outbox(sent_at) WHERE sent_at IS NOT NULL;
@@ -0,0 +1,6 @@
+INSERT INTO permissions (name, description) VALUES
+    ('services:write', 'Operate services, e.g. replay dead-lettered messages');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r, permissions p
+WHERE r.name = 'admin' AND p.name = 'services:write';
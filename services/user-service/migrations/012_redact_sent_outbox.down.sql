@@ -0,0 +1 @@
+-- Erased values can't be restored
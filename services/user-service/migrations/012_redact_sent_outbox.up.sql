@@ -0,0 +1,2 @@
+-- Published messages used to keep their value, which can hold single-use tokens
+UPDATE outbox SET value = '' WHERE sent_at IS NOT NULL;
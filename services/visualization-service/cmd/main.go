@@ -7,13 +7,19 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lucas/shared/consumer"
+	"github.com/lucas/shared/health"
 	"github.com/lucas/shared/utils"
-	"github.com/segmentio/kafka-go"
 )
 
 func main() {
 	port := utils.GetEnvOrDefault("PORT", "8089")
 
+	// Consumers stop and drain on SIGTERM
+	ctx, stop := utils.ShutdownContext()
+	defer stop()
+	background := consumer.NewGroup(ctx)
+
 	router := gin.Default()
 
 	// Api gateway health check
@@ -34,103 +40,17 @@ func main() {
 		})
 	})
 
-	// Start Kafka consumer in a goroutine
-	go startKafkaConsumer()
-
-	log.Printf("Visualization service starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, router))
-}
-
-func startKafkaConsumer() {
+	// Answer the gateway's service pings
 	broker := utils.GetEnvOrDefault("KAFKA_BROKER", "kafka:9092")
-	pingTopic := "service-ping"
-	pongTopic := "service-pong"
-	groupID := "visualization-service-group"
-
-	log.Printf("Starting Kafka consumer. Broker: %s", broker)
-
-	// Wait for Kafka to be available with retry logic
-	var connected bool
-	for retries := 0; retries < 30; retries++ {
-		log.Printf("Attempting to connect to Kafka at %s (attempt %d/30)", broker, retries+1)
-
-		// Test connection by creating a temporary consumer
-		conn, err := kafka.DialLeader(context.Background(), "tcp", broker, pingTopic, 0)
-		if err == nil {
-			conn.Close()
-			log.Println("Successfully connected to Kafka")
-			connected = true
-			break
-		}
-
-		log.Printf("Failed to connect to Kafka: %v. Retrying in 5 seconds...", err)
-		time.Sleep(5 * time.Second)
-	}
-
-	if !connected {
-		log.Printf("Failed to connect to Kafka after 30 attempts. Running without Kafka consumer.")
-		return
-	}
-
-	// Create a new Kafka reader
-	r := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:     []string{broker},
-		Topic:       pingTopic,
-		GroupID:     groupID,
-		StartOffset: kafka.FirstOffset,
-		MinBytes:    1,
-		MaxBytes:    10e6,
-		MaxWait:     time.Second * 5, // Don't wait too long for messages
-	})
-	defer r.Close()
-
-	// Create new Kafka writer
-	w := kafka.NewWriter(kafka.WriterConfig{
-		Brokers:  []string{broker},
-		Topic:    pongTopic,
-		Balancer: &kafka.LeastBytes{}, // Better load balancing
+	background.Go(func(ctx context.Context) {
+		health.ServePings(ctx, broker, "visualization-service", "visualization-service-group")
 	})
-	defer w.Close()
-
-	log.Println("Visualization service Kafka consumer started")
-
-	// Read messages from Kafka
-	for {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		m, err := r.ReadMessage(ctx)
-		cancel()
 
-		if err != nil {
-			if err == context.DeadlineExceeded {
-				// This is normal - no messages received, continue quietly
-				continue
-			}
-			log.Printf("Error reading Kafka message: %v", err)
-			time.Sleep(5 * time.Second) // Wait longer on error
-			continue
-		}
-
-		log.Printf("Received ping: %s", string(m.Value))
-
-		// Check if the ping is specifically for this service
-		if string(m.Key) == "visualization-service" || string(m.Value) == "ping" {
-			// Respond with service status
-			resp := []byte(`{"status":"healthy", "service":"visualization-service", "timestamp":"` + time.Now().Format(time.RFC3339) + `"}`)
-			ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
-			err = w.WriteMessages(ctx,
-				kafka.Message{
-					Key:   []byte("visualization-service"),
-					Value: resp,
-					Time:  time.Now(),
-				},
-			)
-			cancel()
-
-			if err != nil {
-				log.Printf("Error writing kafka pong: %v", err)
-			} else {
-				log.Printf("Sent pong response")
-			}
-		}
+	log.Printf("Visualization service starting on port %s", port)
+	if err := utils.ServeHTTP(ctx, ":"+port, router); err != nil {
+		log.Fatalf("Failed to start HTTP server: %v", err)
 	}
+
+	background.Wait()
+	log.Printf("Visualization service stopped")
 }
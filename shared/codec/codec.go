@@ -0,0 +1,92 @@
+// Package codec encodes Kafka payloads as JSON or Protobuf. The encoding of
+// each message is named by its content-type header, and a message without one
+// is JSON, so producers can switch to Protobuf once their consumers read both.
+//
+// Protobuf support is opt-in per type: a type implementing ProtoMarshaler and
+// ProtoUnmarshaler is encoded by hand with protowire against its schema in
+// shared/proto. Any other type is always sent as JSON.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// HeaderContentType is the Kafka header naming the encoding of a message value
+const HeaderContentType = "content-type"
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+type ProtoMarshaler interface {
+	MarshalProto() []byte
+}
+
+type ProtoUnmarshaler interface {
+	UnmarshalProto(data []byte) error
+}
+
+// Preferred is the encoding this process publishes in when the payload
+// supports it, set with WIRE_FORMAT=protobuf. JSON by default.
+func Preferred() string {
+	if strings.EqualFold(os.Getenv("WIRE_FORMAT"), "protobuf") {
+		return ContentTypeProtobuf
+	}
+	return ContentTypeJSON
+}
+
+// Supports reports whether v can be decoded from contentType.
+func Supports(contentType string, v any) bool {
+	if contentType != ContentTypeProtobuf {
+		return true
+	}
+	_, ok := v.(ProtoUnmarshaler)
+	return ok
+}
+
+// Marshal encodes v as contentType if v supports it, as JSON otherwise, and
+// returns the content type it used.
+func Marshal(contentType string, v any) ([]byte, string, error) {
+	if m, ok := v.(ProtoMarshaler); ok && contentType == ContentTypeProtobuf {
+		return m.MarshalProto(), ContentTypeProtobuf, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, ContentTypeJSON, nil
+}
+
+// Unmarshal decodes data encoded as contentType into v, a pointer. An empty
+// content type is JSON.
+func Unmarshal(contentType string, data []byte, v any) error {
+	switch contentType {
+	case "", ContentTypeJSON:
+		return json.Unmarshal(data, v)
+	case ContentTypeProtobuf:
+		m, ok := v.(ProtoUnmarshaler)
+		if !ok {
+			return fmt.Errorf("%T has no protobuf encoding", v)
+		}
+		return m.UnmarshalProto(data)
+	default:
+		return fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+// ContentTypeOf returns the content type of message, empty when it has none.
+func ContentTypeOf(message kafka.Message) string {
+	for _, h := range message.Headers {
+		if h.Key == HeaderContentType {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
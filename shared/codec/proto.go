@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Helpers for the hand-written protobuf encodings. Like proto3, appenders
+// leave out zero values, and readers ignore fields they don't know so older
+// code can read messages with fields added later.
+
+func AppendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func AppendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func AppendStrings(b []byte, num protowire.Number, v []string) []byte {
+	for _, s := range v {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return b
+}
+
+func AppendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func AppendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+// AppendTime encodes t as a google.protobuf.Timestamp.
+func AppendTime(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var ts []byte
+	ts = AppendInt(ts, 1, t.Unix())
+	ts = AppendInt(ts, 2, int64(t.Nanosecond()))
+
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, ts)
+}
+
+// Field is one field read from a protobuf message.
+type Field struct {
+	typ    protowire.Type
+	varint uint64
+	bytes  []byte
+}
+
+// ReadFields calls fn for every field of the protobuf message in data.
+func ReadFields(data []byte, fn func(num protowire.Number, field Field) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		field := Field{typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			field.varint, n = protowire.ConsumeVarint(data)
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if err := fn(num, field); err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+	}
+	return nil
+}
+
+func (f Field) String() string {
+	return string(f.bytes)
+}
+
+// Bytes returns a copy of the field, which otherwise aliases the message.
+func (f Field) Bytes() []byte {
+	return append([]byte(nil), f.bytes...)
+}
+
+func (f Field) Int() int64 {
+	return int64(f.varint)
+}
+
+func (f Field) Bool() bool {
+	return protowire.DecodeBool(f.varint)
+}
+
+// Time decodes a google.protobuf.Timestamp.
+func (f Field) Time() (time.Time, error) {
+	var seconds, nanos int64
+	err := ReadFields(f.bytes, func(num protowire.Number, field Field) error {
+		switch num {
+		case 1:
+			seconds = field.Int()
+		case 2:
+			nanos = field.Int()
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
@@ -0,0 +1,412 @@
+// Package consumer is the runtime services consume Kafka with. A consumer
+// handles messages on a pool of workers while keeping the messages of a key
+// in order, commits offsets in batches once their messages are handled, and
+// drains on shutdown. It can also skip messages it already processed and move
+// failed ones to retry and dead-letter topics.
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lucas/shared/dedupe"
+	"github.com/segmentio/kafka-go"
+)
+
+// Handler processes a message. The result is recorded for duplicates of the
+// message when deduplication is on. A returned error means the message is
+// retried; wrap it with Permanent when retrying can't help.
+type Handler func(ctx context.Context, message kafka.Message) (result []byte, err error)
+
+// DuplicateFunc is called instead of the Handler for a message that was
+// already processed, with the result recorded the first time. A returned
+// error means the duplicate is retried.
+type DuplicateFunc func(ctx context.Context, message kafka.Message, result []byte) error
+
+// Consumer reads a topic and hands each message to a Handler. Messages are
+// fetched without auto-commit and committed in order once handled.
+type Consumer struct {
+	name           string
+	topic          string // Topic read
+	origin         string // Topic whose retry topics are used, topic itself unless a stage
+	group          string
+	reader         reader
+	handle         Handler
+	concurrency    int
+	attempts       int // In place, before moving a message to the retry topics or dropping it
+	commitInterval time.Duration
+
+	dedupe      dedupe.Store
+	messageID   func(kafka.Message) string
+	onDuplicate DuplicateFunc
+
+	retries     bool
+	retryDelays []time.Duration
+	writer      *kafka.Writer // Of retry and dead-letter topics
+	stage       bool          // Consumes a retry or replay topic
+}
+
+// reader is the part of *kafka.Reader a Consumer uses
+type reader interface {
+	Config() kafka.ReaderConfig
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// New returns a consumer named name in logs, handling one message at a time.
+// A failed message is retried in place, then logged and dropped unless retry
+// topics are enabled.
+func New(name string, reader *kafka.Reader, handle Handler) *Consumer {
+	return newConsumer(name, reader, handle)
+}
+
+func newConsumer(name string, r reader, handle Handler) *Consumer {
+	return &Consumer{
+		name:           name,
+		topic:          r.Config().Topic,
+		origin:         r.Config().Topic,
+		group:          r.Config().GroupID,
+		reader:         r,
+		handle:         handle,
+		concurrency:    1,
+		attempts:       3,
+		commitInterval: time.Second,
+	}
+}
+
+// WithConcurrency handles up to n messages at a time. Messages with the same
+// key, and keyless messages of the same partition, are still handled one at
+// a time in the order they were published.
+func (c *Consumer) WithConcurrency(n int) *Consumer {
+	c.concurrency = max(n, 1)
+	return c
+}
+
+// WithCommitInterval sets how often the offsets of handled messages are
+// committed, one second by default. Messages handled since the last commit
+// are delivered again after a crash.
+func (c *Consumer) WithCommitInterval(interval time.Duration) *Consumer {
+	c.commitInterval = interval
+	return c
+}
+
+// WithDedupe skips messages whose ID was already processed, calling
+// onDuplicate with their recorded result if it isn't nil. Messages messageID
+// returns no ID for are always handled.
+func (c *Consumer) WithDedupe(store dedupe.Store, messageID func(kafka.Message) string, onDuplicate DuplicateFunc) *Consumer {
+	c.dedupe = store
+	c.messageID = messageID
+	c.onDuplicate = onDuplicate
+	return c
+}
+
+// WithAttempts sets how many times a failed message is tried in place, with
+// backoff, before it is moved to the retry topics, or dropped without them.
+func (c *Consumer) WithAttempts(n int) *Consumer {
+	c.attempts = max(n, 1)
+	return c
+}
+
+// WithRetryTopics moves failed messages out of the way so the ones behind
+// them go on: a message that failed its attempts in place, three by default,
+// or failed with a Permanent error, is retried from RetryTopic after each of
+// delays in turn, then lands on DeadLetterTopic with the error of its last
+// attempt. Without delays failed messages are dead-lettered right away.
+// Dead letters sent to ReplayTopic are handled again. The reader must be
+// part of a consumer group.
+func (c *Consumer) WithRetryTopics(delays ...time.Duration) *Consumer {
+	c.retries = true
+	c.retryDelays = delays
+	c.writer = &kafka.Writer{
+		Addr:         kafka.TCP(c.reader.Config().Brokers[0]),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+	return c
+}
+
+// job is a fetched message waiting for a worker
+type job struct {
+	message kafka.Message
+	done    func()
+}
+
+// Run consumes until ctx is cancelled, then drains: it stops fetching,
+// handles the messages already fetched, commits them and closes the reader.
+// Handlers get a context that isn't cancelled with ctx so they can finish;
+// only waiting to retry a failed message is cut short, leaving it
+// uncommitted for the next consumer.
+func (c *Consumer) Run(ctx context.Context) {
+	defer c.reader.Close()
+
+	if c.retries && !c.stage {
+		// Closed once the retry stages below are done
+		defer c.writer.Close()
+	}
+
+	var stages sync.WaitGroup
+	defer stages.Wait()
+
+	if c.retries && !c.stage {
+		c.ensureRetryTopics()
+		for _, stage := range c.stages() {
+			stages.Add(1)
+			go func() {
+				defer stages.Done()
+				stage.Run(ctx)
+			}()
+		}
+	}
+
+	log.Printf("Consumer %s started on %s (group %s, %d workers)", c.name, c.topic, c.group, c.concurrency)
+
+	commits := newCommitTracker(c.name, c.reader)
+	stopCommits := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(c.commitInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				commits.flush()
+			case <-stopCommits:
+				return
+			}
+		}
+	}()
+
+	// Each key goes to the same worker, which handles its messages in order
+	var workers sync.WaitGroup
+	queues := make([]chan job, c.concurrency)
+	for i := range queues {
+		queues[i] = make(chan job, 1)
+		workers.Add(1)
+		go func(queue chan job) {
+			defer workers.Done()
+			for j := range queue {
+				if c.process(ctx, j.message) {
+					j.done()
+				}
+			}
+		}(queues[i])
+	}
+
+	for ctx.Err() == nil {
+		message, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Printf("Consumer %s failed to fetch message: %v", c.name, err)
+			sleep(ctx, time.Second)
+			continue
+		}
+
+		queues[c.worker(message)] <- job{message: message, done: commits.track(message)}
+	}
+
+	log.Printf("Consumer %s draining", c.name)
+	for _, queue := range queues {
+		close(queue)
+	}
+	workers.Wait()
+	close(stopCommits)
+	commits.flush()
+	log.Printf("Consumer %s stopped", c.name)
+}
+
+// worker picks the worker of a message by its key, or its partition when it
+// has none.
+func (c *Consumer) worker(message kafka.Message) int {
+	hash := fnv.New32a()
+	if len(message.Key) > 0 {
+		hash.Write(message.Key)
+	} else {
+		hash.Write([]byte(strconv.Itoa(message.Partition)))
+	}
+	return int(hash.Sum32() % uint32(c.concurrency))
+}
+
+// process handles message, retrying with backoff until it succeeds or is
+// given up on: moved to the next retry topic with retry topics, dropped
+// without them so it doesn't hold up its partition. It returns false when ctx
+// is cancelled before the message is done with, leaving it uncommitted.
+func (c *Consumer) process(ctx context.Context, message kafka.Message) bool {
+	if c.stage && !waitUntilDue(ctx, message) {
+		return false
+	}
+
+	var permanent *permanentError
+	failures := 0
+	for {
+		err := c.processOnce(context.Background(), message)
+		if err == nil {
+			return true
+		}
+
+		// A duplicate being handled elsewhere is waited for, not counted as a
+		// failure: its result is handed out once recorded
+		if !errors.Is(err, dedupe.ErrInProgress) {
+			failures++
+		}
+		if failures >= c.attempts || errors.As(err, &permanent) {
+			if !c.retries {
+				log.Printf("Consumer %s dropping message at %s[%d]@%d after %d attempts: %v",
+					c.name, message.Topic, message.Partition, message.Offset, failures, err)
+				return true
+			}
+			err = c.forward(message, err)
+			if err == nil {
+				return true
+			}
+		}
+
+		wait := min(time.Duration(failures+1)*time.Second, 30*time.Second)
+		log.Printf("Consumer %s failed to handle message at %s[%d]@%d (attempt %d), retrying in %s: %v",
+			c.name, message.Topic, message.Partition, message.Offset, failures, wait, err)
+		if !sleep(ctx, wait) {
+			log.Printf("Consumer %s leaving message at %s[%d]@%d uncommitted on shutdown",
+				c.name, message.Topic, message.Partition, message.Offset)
+			return false
+		}
+	}
+}
+
+func (c *Consumer) processOnce(ctx context.Context, message kafka.Message) error {
+	id := ""
+	if c.dedupe != nil {
+		id = c.messageID(message)
+	}
+	if id == "" {
+		_, err := c.handle(ctx, message)
+		return err
+	}
+
+	claimed, result, err := c.dedupe.Claim(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to claim message %s: %w", id, err)
+	}
+	if !claimed {
+		log.Printf("Consumer %s skipping duplicate message %s", c.name, id)
+		if c.onDuplicate != nil {
+			return c.onDuplicate(ctx, message, result)
+		}
+		return nil
+	}
+
+	result, err = c.handle(ctx, message)
+	if err != nil {
+		if releaseErr := c.dedupe.Release(ctx, id); releaseErr != nil {
+			log.Printf("Consumer %s failed to release message %s: %v", c.name, id, releaseErr)
+		}
+		return err
+	}
+
+	// The message was handled: failing to record it only risks a duplicate later
+	if err := c.dedupe.Complete(ctx, id, result); err != nil {
+		log.Printf("Consumer %s failed to record message %s as processed: %v", c.name, id, err)
+	}
+	return nil
+}
+
+// commitTracker commits the offsets of handled messages in order: a message
+// is only committed once every message fetched before it from its partition
+// is handled, so a crash never skips one. Offsets are committed in batches
+// by flush.
+type commitTracker struct {
+	name    string
+	reader  reader
+	flushMu sync.Mutex // Keeps commits in order
+
+	mu      sync.Mutex
+	pending map[int][]*trackedMessage // By partition, in fetch order
+	ready   map[int]kafka.Message     // Last committable message by partition
+}
+
+type trackedMessage struct {
+	message kafka.Message
+	done    bool
+}
+
+func newCommitTracker(name string, reader reader) *commitTracker {
+	return &commitTracker{
+		name:    name,
+		reader:  reader,
+		pending: make(map[int][]*trackedMessage),
+		ready:   make(map[int]kafka.Message),
+	}
+}
+
+// track registers a fetched message and returns the function marking it handled.
+func (t *commitTracker) track(message kafka.Message) func() {
+	tracked := &trackedMessage{message: message}
+
+	t.mu.Lock()
+	t.pending[message.Partition] = append(t.pending[message.Partition], tracked)
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		tracked.done = true
+		queue := t.pending[message.Partition]
+		handled := 0
+		for handled < len(queue) && queue[handled].done {
+			handled++
+		}
+		if handled > 0 {
+			t.ready[message.Partition] = queue[handled-1].message
+			t.pending[message.Partition] = queue[handled:]
+		}
+	}
+}
+
+// flush commits the offsets that became committable since the last flush.
+func (t *commitTracker) flush() {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+
+	t.mu.Lock()
+	messages := make([]kafka.Message, 0, len(t.ready))
+	for partition, message := range t.ready {
+		messages = append(messages, message)
+		delete(t.ready, partition)
+	}
+	t.mu.Unlock()
+
+	if len(messages) == 0 {
+		return
+	}
+	if err := t.reader.CommitMessages(context.Background(), messages...); err != nil {
+		log.Printf("Consumer %s failed to commit %d offsets: %v", t.name, len(messages), err)
+
+		// Try again next time, unless later messages became committable meanwhile
+		t.mu.Lock()
+		for _, message := range messages {
+			if _, ok := t.ready[message.Partition]; !ok {
+				t.ready[message.Partition] = message
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// sleep waits for d, returning false when ctx is cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
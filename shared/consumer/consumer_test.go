@@ -0,0 +1,285 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeReader serves messages in order, then blocks until ctx is cancelled.
+type fakeReader struct {
+	messages chan kafka.Message
+
+	mu        sync.Mutex
+	fetched   int
+	committed map[int]int64 // Highest committed offset by partition
+}
+
+func newFakeReader(messages []kafka.Message) *fakeReader {
+	r := &fakeReader{
+		messages:  make(chan kafka.Message, len(messages)),
+		committed: make(map[int]int64),
+	}
+	for _, message := range messages {
+		r.messages <- message
+	}
+	return r
+}
+
+func (r *fakeReader) Config() kafka.ReaderConfig {
+	return kafka.ReaderConfig{Topic: "test", GroupID: "test-group"}
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case message := <-r.messages:
+		r.mu.Lock()
+		r.fetched++
+		r.mu.Unlock()
+		return message, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(_ context.Context, messages ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, message := range messages {
+		if offset, ok := r.committed[message.Partition]; ok && message.Offset <= offset {
+			return fmt.Errorf("offset %d of partition %d committed after %d", message.Offset, message.Partition, offset)
+		}
+		r.committed[message.Partition] = message.Offset
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error { return nil }
+
+func (r *fakeReader) committedOffset(partition int) (int64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	offset, ok := r.committed[partition]
+	return offset, ok
+}
+
+// run runs c until stop returns true or the test times out, then cancels it
+// and waits for it to drain.
+func run(t *testing.T, c *Consumer, stop func() bool) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !stop() {
+		if time.Now().After(deadline) {
+			cancel()
+			t.Fatal("timed out waiting for the consumer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("consumer didn't stop")
+	}
+}
+
+func TestKeepsKeysInOrderUnderConcurrency(t *testing.T) {
+	const keys, perKey = 8, 25
+
+	var messages []kafka.Message
+	for i := 0; i < perKey; i++ {
+		for k := 0; k < keys; k++ {
+			messages = append(messages, kafka.Message{
+				Partition: k % 2,
+				Offset:    int64(len(messages)),
+				Key:       []byte(fmt.Sprintf("key-%d", k)),
+				Value:     []byte(fmt.Sprint(i)),
+			})
+		}
+	}
+	reader := newFakeReader(messages)
+
+	var (
+		mu       sync.Mutex
+		seen     = make(map[string][]string)
+		handled  atomic.Int32
+		inFlight atomic.Int32
+		maxBusy  atomic.Int32
+	)
+	handle := func(_ context.Context, message kafka.Message) ([]byte, error) {
+		busy := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			peak := maxBusy.Load()
+			if busy <= peak || maxBusy.CompareAndSwap(peak, busy) {
+				break
+			}
+		}
+
+		time.Sleep(time.Duration(len(message.Value)+int(message.Offset)%3) * time.Millisecond)
+
+		mu.Lock()
+		seen[string(message.Key)] = append(seen[string(message.Key)], string(message.Value))
+		mu.Unlock()
+		handled.Add(1)
+		return nil, nil
+	}
+
+	c := newConsumer("test", reader, handle).WithConcurrency(4)
+	run(t, c, func() bool { return handled.Load() == int32(len(messages)) })
+
+	for k := 0; k < keys; k++ {
+		key := fmt.Sprintf("key-%d", k)
+		if len(seen[key]) != perKey {
+			t.Fatalf("%s handled %d times, want %d", key, len(seen[key]), perKey)
+		}
+		for i, value := range seen[key] {
+			if value != fmt.Sprint(i) {
+				t.Fatalf("%s handled in order %v, want publication order", key, seen[key])
+			}
+		}
+	}
+	if maxBusy.Load() < 2 {
+		t.Errorf("at most %d messages handled at a time, want concurrency", maxBusy.Load())
+	}
+}
+
+func TestCommitsOnlyHandledPrefix(t *testing.T) {
+	reader := newFakeReader(nil)
+	commits := newCommitTracker("test", reader)
+
+	first := commits.track(kafka.Message{Partition: 0, Offset: 0})
+	second := commits.track(kafka.Message{Partition: 0, Offset: 1})
+	third := commits.track(kafka.Message{Partition: 0, Offset: 2})
+
+	second()
+	commits.flush()
+	if offset, ok := reader.committedOffset(0); ok {
+		t.Fatalf("committed offset %d before offset 0 was handled", offset)
+	}
+
+	first()
+	commits.flush()
+	if offset, _ := reader.committedOffset(0); offset != 1 {
+		t.Fatalf("committed offset %d, want 1", offset)
+	}
+
+	third()
+	commits.flush()
+	if offset, _ := reader.committedOffset(0); offset != 2 {
+		t.Fatalf("committed offset %d, want 2", offset)
+	}
+}
+
+func TestDrainsAndCommitsOnShutdown(t *testing.T) {
+	var messages []kafka.Message
+	for i := 0; i < 10; i++ {
+		messages = append(messages, kafka.Message{Partition: 0, Offset: int64(i), Key: []byte(fmt.Sprint(i % 3))})
+	}
+	reader := newFakeReader(messages)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	var handled atomic.Int32
+	handle := func(ctx context.Context, message kafka.Message) ([]byte, error) {
+		once.Do(func() { close(started) })
+		<-release
+		if ctx.Err() != nil {
+			return nil, errors.New("handler context cancelled on shutdown")
+		}
+		handled.Add(1)
+		return nil, nil
+	}
+
+	// Nothing is committed before shutdown, so any commit comes from the drain
+	c := newConsumer("test", reader, handle).WithConcurrency(3).WithCommitInterval(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+
+	<-started
+	cancel()
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("consumer didn't drain")
+	}
+
+	reader.mu.Lock()
+	fetched := reader.fetched
+	reader.mu.Unlock()
+	if fetched == 0 || int(handled.Load()) != fetched {
+		t.Fatalf("handled %d of %d fetched messages", handled.Load(), fetched)
+	}
+	offset, ok := reader.committedOffset(0)
+	if !ok || offset != int64(fetched-1) {
+		t.Fatalf("committed offset %d (%t), want %d", offset, ok, fetched-1)
+	}
+}
+
+func TestDropsPoisonMessagesWithoutRetryTopics(t *testing.T) {
+	reader := newFakeReader([]kafka.Message{
+		{Partition: 0, Offset: 0, Value: []byte("poison")},
+		{Partition: 0, Offset: 1, Value: []byte("ok")},
+	})
+
+	var attempts, handled atomic.Int32
+	handle := func(_ context.Context, message kafka.Message) ([]byte, error) {
+		if string(message.Value) == "poison" {
+			attempts.Add(1)
+			return nil, errors.New("can't handle this")
+		}
+		handled.Add(1)
+		return nil, nil
+	}
+
+	c := newConsumer("test", reader, handle).WithAttempts(1).WithCommitInterval(10 * time.Millisecond)
+	run(t, c, func() bool {
+		offset, ok := reader.committedOffset(0)
+		return ok && offset == 1
+	})
+
+	if attempts.Load() != 1 || handled.Load() != 1 {
+		t.Errorf("poison message tried %d times and next handled %d times, want 1 and 1", attempts.Load(), handled.Load())
+	}
+}
+
+func TestDropsPermanentFailuresRightAway(t *testing.T) {
+	reader := newFakeReader([]kafka.Message{{Partition: 0, Offset: 0}})
+
+	var attempts atomic.Int32
+	handle := func(context.Context, kafka.Message) ([]byte, error) {
+		attempts.Add(1)
+		return nil, Permanent(errors.New("unparseable"))
+	}
+
+	c := newConsumer("test", reader, handle).WithCommitInterval(10 * time.Millisecond)
+	run(t, c, func() bool {
+		_, ok := reader.committedOffset(0)
+		return ok
+	})
+
+	if attempts.Load() != 1 {
+		t.Errorf("permanent failure tried %d times, want 1", attempts.Load())
+	}
+}
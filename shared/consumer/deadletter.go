@@ -0,0 +1,208 @@
+package consumer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ErrDeadLetterNotFound is returned by ReplayDeadLetter for a topic that
+// isn't a dead-letter topic, or an offset it doesn't hold
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// DeadLetter is a message that exhausted its retries, as listed for
+// inspection. Value holds the payload as text, or base64 when it isn't
+// UTF-8, as told by ValueEncoding.
+type DeadLetter struct {
+	Partition         int               `json:"partition"`
+	Offset            int64             `json:"offset"`
+	OriginalTopic     string            `json:"original_topic"`
+	OriginalPartition int               `json:"original_partition"`
+	OriginalOffset    int64             `json:"original_offset"`
+	Key               string            `json:"key,omitempty"`
+	Value             string            `json:"value"`
+	ValueEncoding     string            `json:"value_encoding"`
+	Headers           map[string]string `json:"headers,omitempty"`
+	Attempts          int               `json:"attempts"`
+	Error             string            `json:"error"`
+	FailedAt          time.Time         `json:"failed_at"`
+}
+
+// DeadLetterTopics returns the dead-letter topics of the cluster.
+func DeadLetterTopics(ctx context.Context, broker string) ([]string, error) {
+	conn, err := kafka.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	partitions, err := conn.ReadPartitions()
+	if err != nil {
+		return nil, err
+	}
+
+	var topics []string
+	for _, partition := range partitions {
+		if partition.ID == 0 && strings.HasSuffix(partition.Topic, deadLetterSuffix) {
+			topics = append(topics, partition.Topic)
+		}
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// ListDeadLetters returns the last limit messages of a dead-letter topic,
+// most recent first. Replayed messages stay listed.
+func ListDeadLetters(ctx context.Context, broker, topic string, limit int) ([]DeadLetter, error) {
+	if !strings.HasSuffix(topic, deadLetterSuffix) {
+		return nil, ErrDeadLetterNotFound
+	}
+
+	conn, err := kafka.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return nil, err
+	}
+	partitions, err := conn.ReadPartitions(topic)
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var letters []DeadLetter
+	for _, partition := range partitions {
+		messages, err := readTail(ctx, broker, partition.Topic, partition.ID, limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read partition %d of %s: %w", partition.ID, partition.Topic, err)
+		}
+		for _, message := range messages {
+			letters = append(letters, deadLetterOf(message))
+		}
+	}
+
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.After(letters[j].FailedAt) })
+	if len(letters) > limit {
+		letters = letters[:limit]
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetter hands the message at offset of a partition of a
+// dead-letter topic back to the consumer group that failed it, through its
+// replay topic. It is retried again if it fails.
+func ReplayDeadLetter(ctx context.Context, broker, topic string, partition int, offset int64) error {
+	if !strings.HasSuffix(topic, deadLetterSuffix) {
+		return ErrDeadLetterNotFound
+	}
+
+	conn, err := kafka.DialLeader(ctx, "tcp", broker, topic, partition)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	first, last, err := conn.ReadOffsets()
+	if err != nil {
+		return err
+	}
+	if offset < first || offset >= last {
+		return ErrDeadLetterNotFound
+	}
+	if _, err := conn.Seek(offset, kafka.SeekAbsolute); err != nil {
+		return err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return err
+	}
+	message, err := conn.ReadMessage(10e6)
+	if err != nil {
+		return err
+	}
+
+	// Where the message came from is kept so it is reported again if it fails
+	headers := append(withoutRetryHeaders(message.Headers),
+		kafka.Header{Key: HeaderOriginalTopic, Value: []byte(header(message, HeaderOriginalTopic))},
+		kafka.Header{Key: HeaderOriginalPartition, Value: []byte(header(message, HeaderOriginalPartition))},
+		kafka.Header{Key: HeaderOriginalOffset, Value: []byte(header(message, HeaderOriginalOffset))},
+	)
+
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(broker),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+	defer writer.Close()
+
+	return writer.WriteMessages(ctx, kafka.Message{
+		Topic:   strings.TrimSuffix(topic, deadLetterSuffix) + replaySuffix,
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: headers,
+	})
+}
+
+// readTail reads the last limit messages of a partition.
+func readTail(ctx context.Context, broker, topic string, partition, limit int) ([]kafka.Message, error) {
+	conn, err := kafka.DialLeader(ctx, "tcp", broker, topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	first, last, err := conn.ReadOffsets()
+	if err != nil || first == last {
+		return nil, err
+	}
+	start := max(first, last-int64(limit))
+	if _, err := conn.Seek(start, kafka.SeekAbsolute); err != nil {
+		return nil, err
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return nil, err
+	}
+
+	var messages []kafka.Message
+	for len(messages) < int(last-start) {
+		message, err := conn.ReadMessage(10e6)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func deadLetterOf(message kafka.Message) DeadLetter {
+	letter := DeadLetter{
+		Partition:     message.Partition,
+		Offset:        message.Offset,
+		OriginalTopic: header(message, HeaderOriginalTopic),
+		Key:           string(message.Key),
+		Value:         string(message.Value),
+		ValueEncoding: "text",
+		Error:         header(message, HeaderError),
+	}
+	letter.OriginalPartition, _ = strconv.Atoi(header(message, HeaderOriginalPartition))
+	letter.OriginalOffset, _ = strconv.ParseInt(header(message, HeaderOriginalOffset), 10, 64)
+	letter.Attempts, _ = strconv.Atoi(header(message, HeaderAttempts))
+	letter.FailedAt, _ = time.Parse(time.RFC3339Nano, header(message, HeaderFailedAt))
+
+	if !utf8.Valid(message.Value) {
+		letter.Value = base64.StdEncoding.EncodeToString(message.Value)
+		letter.ValueEncoding = "base64"
+	}
+	for _, h := range withoutRetryHeaders(message.Headers) {
+		if letter.Headers == nil {
+			letter.Headers = make(map[string]string)
+		}
+		letter.Headers[h.Key] = string(h.Value)
+	}
+	return letter
+}
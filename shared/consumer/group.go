@@ -0,0 +1,31 @@
+package consumer
+
+import (
+	"context"
+	"sync"
+)
+
+// Group runs the consumers and other background loops of a service until
+// its context is cancelled, so shutdown can wait for all of them to drain.
+type Group struct {
+	ctx context.Context
+	wg  sync.WaitGroup
+}
+
+func NewGroup(ctx context.Context) *Group {
+	return &Group{ctx: ctx}
+}
+
+// Go runs run in the background with the group's context.
+func (g *Group) Go(run func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		run(g.ctx)
+	}()
+}
+
+// Wait returns once every loop started with Go returned.
+func (g *Group) Wait() {
+	g.wg.Wait()
+}
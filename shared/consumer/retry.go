@@ -0,0 +1,208 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucas/shared/utils"
+	"github.com/segmentio/kafka-go"
+)
+
+// Headers added to messages moved to retry and dead-letter topics. The
+// message keeps its key, value and other headers.
+const (
+	HeaderOriginalTopic     = "retry-original-topic"
+	HeaderOriginalPartition = "retry-original-partition"
+	HeaderOriginalOffset    = "retry-original-offset"
+	HeaderAttempts          = "retry-attempts" // Failed attempts so far
+	HeaderNotBefore         = "retry-not-before"
+	HeaderError             = "retry-error" // Error of the last attempt
+	HeaderFailedAt          = "retry-failed-at"
+)
+
+const headerRetryPrefix = "retry-"
+
+// DefaultRetryDelays are the delays of the retry topics consumers use by default.
+var DefaultRetryDelays = []time.Duration{10 * time.Second, time.Minute, 10 * time.Minute}
+
+// RetryTopic names the topic holding the messages of topic that group
+// retries after delay. Retry topics are per group, so a message one group
+// failed isn't handled again by the others.
+func RetryTopic(topic, group string, delay time.Duration) string {
+	label := strconv.Itoa(int(delay/time.Second)) + "s"
+	if delay%time.Minute == 0 {
+		label = strconv.Itoa(int(delay/time.Minute)) + "m"
+	}
+	return topic + "." + group + ".retry." + label
+}
+
+// DeadLetterTopic names the topic holding the messages of topic that group
+// failed to handle, after exhausting their retries.
+func DeadLetterTopic(topic, group string) string {
+	return topic + "." + group + deadLetterSuffix
+}
+
+// ReplayTopic names the topic group consumes replayed dead letters from.
+func ReplayTopic(topic, group string) string {
+	return topic + "." + group + replaySuffix
+}
+
+const (
+	deadLetterSuffix = ".dlq"
+	replaySuffix     = ".replay"
+)
+
+// Permanent marks err as one retrying won't fix, such as an unparseable
+// message, so the message goes straight to the dead-letter topic.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// stages returns the consumers of the retry topics and the replay topic.
+func (c *Consumer) stages() []*Consumer {
+	topics := []string{ReplayTopic(c.origin, c.group)}
+	for _, delay := range c.retryDelays {
+		topics = append(topics, RetryTopic(c.origin, c.group, delay))
+	}
+
+	var stages []*Consumer
+	for _, topic := range topics {
+		config := c.reader.Config()
+		config.Topic = topic
+
+		stage := *c
+		stage.name = c.name + " " + strings.TrimPrefix(topic, c.origin+"."+c.group+".")
+		stage.topic = topic
+		stage.reader = kafka.NewReader(config)
+		stage.concurrency = 1 // Keeps the messages of a partition in due order
+		stage.stage = true
+		stages = append(stages, &stage)
+	}
+	return stages
+}
+
+// ensureRetryTopics creates the retry, replay and dead-letter topics with as
+// many partitions as the consumed topic, falling back on broker auto-creation.
+func (c *Consumer) ensureRetryTopics() {
+	broker := c.reader.Config().Brokers[0]
+
+	partitions := 1
+	if conn, err := kafka.Dial("tcp", broker); err == nil {
+		if found, err := conn.ReadPartitions(c.origin); err == nil && len(found) > 0 {
+			partitions = len(found)
+		}
+		conn.Close()
+	}
+
+	topics := []string{DeadLetterTopic(c.origin, c.group), ReplayTopic(c.origin, c.group)}
+	for _, delay := range c.retryDelays {
+		topics = append(topics, RetryTopic(c.origin, c.group, delay))
+	}
+	for _, topic := range topics {
+		err := utils.CreateKafkaTopic(broker, kafka.TopicConfig{Topic: topic, NumPartitions: partitions, ReplicationFactor: 1})
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			log.Printf("Consumer %s failed to create topic %s, relying on broker auto-creation: %v", c.name, topic, err)
+		}
+	}
+}
+
+// forward moves a message that failed with cause to the next retry topic, or
+// to the dead-letter topic once it exhausted them.
+func (c *Consumer) forward(message kafka.Message, cause error) error {
+	attempts, _ := strconv.Atoi(header(message, HeaderAttempts))
+	attempts++
+
+	headers := withoutRetryHeaders(message.Headers)
+
+	// The first failure records where the message came from
+	origin := []kafka.Header{
+		{Key: HeaderOriginalTopic, Value: []byte(c.origin)},
+		{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(message.Partition))},
+		{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(message.Offset, 10))},
+	}
+	if header(message, HeaderOriginalTopic) != "" {
+		origin = []kafka.Header{
+			{Key: HeaderOriginalTopic, Value: []byte(header(message, HeaderOriginalTopic))},
+			{Key: HeaderOriginalPartition, Value: []byte(header(message, HeaderOriginalPartition))},
+			{Key: HeaderOriginalOffset, Value: []byte(header(message, HeaderOriginalOffset))},
+		}
+	}
+	headers = append(headers, origin...)
+	headers = append(headers,
+		kafka.Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: HeaderError, Value: []byte(cause.Error())},
+	)
+
+	var permanent *permanentError
+	topic := DeadLetterTopic(c.origin, c.group)
+	if attempts <= len(c.retryDelays) && !errors.As(cause, &permanent) {
+		delay := c.retryDelays[attempts-1]
+		topic = RetryTopic(c.origin, c.group, delay)
+		headers = append(headers, kafka.Header{Key: HeaderNotBefore, Value: []byte(time.Now().Add(delay).Format(time.RFC3339Nano))})
+	} else {
+		headers = append(headers, kafka.Header{Key: HeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := c.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic,
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move message to %s: %w", topic, err)
+	}
+
+	if topic == DeadLetterTopic(c.origin, c.group) {
+		log.Printf("Consumer %s dead-lettered message at %s[%d]@%d after %d attempts: %v",
+			c.name, message.Topic, message.Partition, message.Offset, attempts, cause)
+	} else {
+		log.Printf("Consumer %s moved message at %s[%d]@%d to %s (attempt %d): %v",
+			c.name, message.Topic, message.Partition, message.Offset, topic, attempts, cause)
+	}
+	return nil
+}
+
+// waitUntilDue waits until a retried message is due. It returns false when
+// ctx is cancelled first.
+func waitUntilDue(ctx context.Context, message kafka.Message) bool {
+	notBefore, err := time.Parse(time.RFC3339Nano, header(message, HeaderNotBefore))
+	if err != nil {
+		return true
+	}
+	return sleep(ctx, time.Until(notBefore))
+}
+
+// withoutRetryHeaders returns the headers of a message as first received.
+func withoutRetryHeaders(headers []kafka.Header) []kafka.Header {
+	kept := make([]kafka.Header, 0, len(headers)+6)
+	for _, h := range headers {
+		if !strings.HasPrefix(h.Key, headerRetryPrefix) {
+			kept = append(kept, h)
+		}
+	}
+	return kept
+}
+
+func header(message kafka.Message, key string) string {
+	for _, h := range message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,24 @@
+// Package dedupe records which messages a consumer processed, and what it
+// answered, so a message Kafka delivers again isn't processed twice.
+package dedupe
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrInProgress is returned by Claim while another consumer processes the message
+var ErrInProgress = errors.New("message is being processed")
+
+// Store records processed message IDs.
+type Store interface {
+	// Claim marks id as being processed. It returns false and the recorded
+	// result when id was already processed, and ErrInProgress when it is being
+	// processed.
+	Claim(ctx context.Context, id string) (claimed bool, result []byte, err error)
+	// Complete records that id was processed, with the result to hand out to
+	// duplicates.
+	Complete(ctx context.Context, id string, result []byte) error
+	// Release drops the claim on id after failing to process it, so it can be retried.
+	Release(ctx context.Context, id string) error
+}
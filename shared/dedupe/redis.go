@@ -0,0 +1,68 @@
+package dedupe
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	pendingValue = "pending"
+	donePrefix   = "done:"
+)
+
+// RedisStore keeps processed IDs in Redis under a prefix for a fixed time.
+type RedisStore struct {
+	client   *redis.Client
+	prefix   string
+	ttl      time.Duration // How long a processed ID is remembered
+	claimTTL time.Duration // How long a claim survives a consumer that died holding it
+}
+
+// NewRedisStore remembers processed IDs for ttl. It must be longer than a
+// message can wait before being delivered again.
+func NewRedisStore(client *redis.Client, prefix string, ttl time.Duration) *RedisStore {
+	return &RedisStore{
+		client:   client,
+		prefix:   prefix,
+		ttl:      ttl,
+		claimTTL: time.Minute,
+	}
+}
+
+func (s *RedisStore) Claim(ctx context.Context, id string) (bool, []byte, error) {
+	claimed, err := s.client.SetNX(ctx, s.key(id), pendingValue, s.claimTTL).Result()
+	if err != nil || claimed {
+		return claimed, nil, err
+	}
+
+	value, err := s.client.Get(ctx, s.key(id)).Result()
+	if errors.Is(err, redis.Nil) {
+		// Released or expired in between, try again
+		return s.Claim(ctx, id)
+	}
+	if err != nil {
+		return false, nil, err
+	}
+
+	result, done := strings.CutPrefix(value, donePrefix)
+	if !done {
+		return false, nil, ErrInProgress
+	}
+	return false, []byte(result), nil
+}
+
+func (s *RedisStore) Complete(ctx context.Context, id string, result []byte) error {
+	return s.client.Set(ctx, s.key(id), donePrefix+string(result), s.ttl).Err()
+}
+
+func (s *RedisStore) Release(ctx context.Context, id string) error {
+	return s.client.Del(ctx, s.key(id)).Err()
+}
+
+func (s *RedisStore) key(id string) string {
+	return s.prefix + ":" + id
+}
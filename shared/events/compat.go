@@ -0,0 +1,156 @@
+package events
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Check verifies that every event type can evolve without breaking its
+// consumers:
+//
+//   - versions are numbered 1 to latest without gaps,
+//   - each version but the latest has an upcaster to the next one, so
+//     consumers on the new version read events published before it,
+//   - each version keeps every field of the previous one with the same JSON
+//     type, so consumers still on the old version read events published
+//     after it during a rollout.
+//
+// A change that can't meet these rules needs a new event type. Producers run
+// Check at startup so an incompatible schema never reaches the topic.
+func (r *Registry) Check() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.types))
+	for name := range r.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		t := r.types[name]
+		for version := 1; version <= t.latest; version++ {
+			if _, ok := t.versions[version]; !ok {
+				errs = append(errs, fmt.Errorf("%s: v%d is not registered", name, version))
+			}
+		}
+		for version := range t.upcasters {
+			if version < 1 || version >= t.latest {
+				errs = append(errs, fmt.Errorf("%s: upcaster from v%d has no version to upcast to", name, version))
+			}
+		}
+
+		for version := 1; version < t.latest; version++ {
+			previous, next := t.versions[version], t.versions[version+1]
+			if previous == nil || next == nil {
+				continue
+			}
+			if _, ok := t.upcasters[version]; !ok {
+				errs = append(errs, fmt.Errorf("%s: no upcaster from v%d to v%d", name, version, version+1))
+			}
+			for _, problem := range compareFields(jsonFields(previous), jsonFields(next)) {
+				errs = append(errs, fmt.Errorf("%s: v%d %s", name, version+1, problem))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// compareFields lists the fields of previous that next removed or retyped.
+func compareFields(previous, next map[string]string) []string {
+	var problems []string
+	for path, kind := range previous {
+		nextKind, ok := next[path]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("removes field %s", path))
+		case kind != nextKind && kind != "any" && nextKind != "any":
+			problems = append(problems, fmt.Sprintf("changes field %s from %s to %s", path, kind, nextKind))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// jsonFields maps the path of every JSON field of t, nested objects
+// included, to its JSON type.
+func jsonFields(t reflect.Type) map[string]string {
+	fields := make(map[string]string)
+	collectFields(t, "", fields)
+	return fields
+}
+
+func collectFields(t reflect.Type, prefix string, fields map[string]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || marshalsItself(t) {
+		return
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		// Untagged embedded structs have their fields promoted
+		if field.Anonymous && name == "" {
+			collectFields(field.Type, prefix, fields)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fields[prefix+name] = jsonKind(field.Type)
+		collectFields(field.Type, prefix+name+".", fields)
+	}
+}
+
+var (
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+func marshalsItself(t reflect.Type) bool {
+	return t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) ||
+		t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)
+}
+
+// jsonKind is the JSON type values of t encode to. Types marshalling
+// themselves, like time.Time, are assumed to encode to strings.
+func jsonKind(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if marshalsItself(t) {
+		return "string"
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string" // Base64
+		}
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return "any"
+	}
+}
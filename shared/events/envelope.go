@@ -0,0 +1,70 @@
+// Package events defines the envelope every event published on Kafka is
+// wrapped in, and the registry of the Go types each event type and schema
+// version carries.
+//
+// Producers build envelopes with Registry.NewEnvelope, which refuses payloads
+// that aren't the latest registered schema of their type, and encode them
+// with package codec. Consumers read them with Registry.Decode, which upcasts
+// older payloads to the latest schema, and then DataAs. The payload is JSON
+// whatever the envelope's encoding.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Envelope is the wire format of an event.
+type Envelope struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	SchemaVersion int             `json:"schema_version"`
+	Source        string          `json:"source"`            // Service that published the event
+	Subject       string          `json:"subject,omitempty"` // What the event is about, e.g. the user ID
+	Time          time.Time       `json:"time"`
+	TraceParent   string          `json:"traceparent,omitempty"` // W3C trace context of the operation that caused the event
+	Data          json.RawMessage `json:"data,omitempty"`
+}
+
+// DataAs decodes the payload of env into T. Use it on envelopes returned by
+// Registry.Decode, whose payload is already in the latest schema.
+func DataAs[T any](env *Envelope) (T, error) {
+	var data T
+	if len(env.Data) == 0 {
+		return data, nil
+	}
+	if err := json.Unmarshal(env.Data, &data); err != nil {
+		return data, fmt.Errorf("invalid %s v%d payload: %w", env.Type, env.SchemaVersion, err)
+	}
+	return data, nil
+}
+
+type traceKey struct{}
+
+// WithTraceParent returns a context whose events carry the W3C traceparent
+// value, so consumers can tie their work to the operation that caused it.
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceParent)
+}
+
+// TraceParent returns the trace context of ctx, starting a new trace when
+// ctx doesn't carry one.
+func TraceParent(ctx context.Context) string {
+	if traceParent, ok := ctx.Value(traceKey{}).(string); ok && traceParent != "" {
+		return traceParent
+	}
+
+	var ids [24]byte
+	_, _ = rand.Read(ids[:])
+	return "00-" + hex.EncodeToString(ids[:16]) + "-" + hex.EncodeToString(ids[16:]) + "-01"
+}
+
+func newEventID() string {
+	return uuid.New().String()
+}
@@ -0,0 +1,46 @@
+package events
+
+import (
+	"github.com/lucas/shared/codec"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encoding of shared/proto/events.proto
+
+func (e Envelope) MarshalProto() []byte {
+	var b []byte
+	b = codec.AppendString(b, 1, e.ID)
+	b = codec.AppendString(b, 2, e.Type)
+	b = codec.AppendInt(b, 3, int64(e.SchemaVersion))
+	b = codec.AppendString(b, 4, e.Source)
+	b = codec.AppendString(b, 5, e.Subject)
+	b = codec.AppendTime(b, 6, e.Time)
+	b = codec.AppendString(b, 7, e.TraceParent)
+	b = codec.AppendBytes(b, 8, e.Data)
+	return b
+}
+
+func (e *Envelope) UnmarshalProto(data []byte) error {
+	return codec.ReadFields(data, func(num protowire.Number, field codec.Field) error {
+		var err error
+		switch num {
+		case 1:
+			e.ID = field.String()
+		case 2:
+			e.Type = field.String()
+		case 3:
+			e.SchemaVersion = int(field.Int())
+		case 4:
+			e.Source = field.String()
+		case 5:
+			e.Subject = field.String()
+		case 6:
+			e.Time, err = field.Time()
+		case 7:
+			e.TraceParent = field.String()
+		case 8:
+			e.Data = field.Bytes()
+		}
+		return err
+	})
+}
@@ -0,0 +1,27 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lucas/shared/proto/prototest"
+)
+
+func TestProtoMatchesSchema(t *testing.T) {
+	prototest.RoundTrip(t, prototest.Message(t, "events.proto", "Envelope"),
+		Envelope{
+			ID:            "event-1",
+			Type:          "account.created",
+			SchemaVersion: 2,
+			Source:        "user-service",
+			Subject:       "42",
+			Time:          time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC),
+			TraceParent:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			Data:          json.RawMessage(`{"email":"ada@example.com"}`),
+		},
+		`{"id": "event-1", "type": "account.created", "schema_version": "2", "source": "user-service",
+		  "subject": "42", "time": "2024-05-01T09:00:00Z",
+		  "traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		  "data": "eyJlbWFpbCI6ImFkYUBleGFtcGxlLmNvbSJ9"}`)
+}
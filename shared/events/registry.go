@@ -0,0 +1,156 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/lucas/shared/codec"
+)
+
+// Upcaster converts a payload from one schema version to the next.
+type Upcaster func(data json.RawMessage) (json.RawMessage, error)
+
+// Registry knows the Go type of every schema version of each event type.
+type Registry struct {
+	mu    sync.RWMutex
+	types map[string]*eventType
+}
+
+type eventType struct {
+	versions  map[int]reflect.Type
+	upcasters map[int]Upcaster // By the version they convert from
+	latest    int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{types: make(map[string]*eventType)}
+}
+
+// Register records T as the payload of version of eventType. Versions start
+// at 1; the highest registered one is what producers publish.
+func Register[T any](r *Registry, eventType string, version int) {
+	if version < 1 {
+		panic(fmt.Sprintf("events: invalid version %d of %s", version, eventType))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.typeLocked(eventType)
+	if _, ok := t.versions[version]; ok {
+		panic(fmt.Sprintf("events: %s v%d registered twice", eventType, version))
+	}
+	t.versions[version] = reflect.TypeFor[T]()
+	t.latest = max(t.latest, version)
+}
+
+// RegisterUpcaster records how payloads of version of eventType are converted
+// to version+1.
+func (r *Registry) RegisterUpcaster(eventType string, version int, upcast Upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.typeLocked(eventType).upcasters[version] = upcast
+}
+
+func (r *Registry) typeLocked(name string) *eventType {
+	t, ok := r.types[name]
+	if !ok {
+		t = &eventType{versions: make(map[int]reflect.Type), upcasters: make(map[int]Upcaster)}
+		r.types[name] = t
+	}
+	return t
+}
+
+// NewEnvelope wraps data in an envelope of the latest schema version of
+// eventType. data must be of the Go type registered for that version.
+func (r *Registry) NewEnvelope(ctx context.Context, eventType, source, subject string, data any) (*Envelope, error) {
+	r.mu.RLock()
+	t, ok := r.types[eventType]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unregistered event type %s", eventType)
+	}
+
+	if got := reflect.TypeOf(data); got != t.versions[t.latest] {
+		return nil, fmt.Errorf("%s v%d payload must be a %v, got %v", eventType, t.latest, t.versions[t.latest], got)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s payload: %w", eventType, err)
+	}
+
+	return &Envelope{
+		ID:            newEventID(),
+		Type:          eventType,
+		SchemaVersion: t.latest,
+		Source:        source,
+		Subject:       subject,
+		Time:          time.Now().UTC(),
+		TraceParent:   TraceParent(ctx),
+		Data:          raw,
+	}, nil
+}
+
+// Decode reads an envelope encoded as contentType and upcasts its payload to
+// the latest schema version this process knows. Payloads of unregistered
+// types, and of versions newer than the latest known, are returned as they
+// are: consumers ignore types they don't handle, and newer versions stay
+// readable as the older one (see Check).
+func (r *Registry) Decode(contentType string, value []byte) (*Envelope, error) {
+	var env Envelope
+	if err := codec.Unmarshal(contentType, value, &env); err != nil {
+		return nil, fmt.Errorf("invalid event envelope: %w", err)
+	}
+	if env.SchemaVersion == 0 && contentType != codec.ContentTypeProtobuf {
+		if err := upgradeLegacy(value, &env); err != nil {
+			return nil, err
+		}
+	}
+	if env.Type == "" {
+		return nil, errors.New("invalid event envelope: missing type")
+	}
+
+	r.mu.RLock()
+	t, ok := r.types[env.Type]
+	r.mu.RUnlock()
+	if !ok {
+		return &env, nil
+	}
+
+	for env.SchemaVersion < t.latest {
+		upcast, ok := t.upcasters[env.SchemaVersion]
+		if !ok {
+			return nil, fmt.Errorf("no upcaster for %s v%d", env.Type, env.SchemaVersion)
+		}
+		data, err := upcast(env.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upcast %s v%d: %w", env.Type, env.SchemaVersion, err)
+		}
+		env.Data = data
+		env.SchemaVersion++
+	}
+	return &env, nil
+}
+
+// upgradeLegacy reads events published before the envelope existed, which
+// carried the subject as user_id and no schema version, as version 1.
+func upgradeLegacy(value []byte, env *Envelope) error {
+	var legacy struct {
+		UserID    string    `json:"user_id"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	if err := json.Unmarshal(value, &legacy); err != nil {
+		return fmt.Errorf("invalid legacy event: %w", err)
+	}
+
+	env.SchemaVersion = 1
+	env.Subject = legacy.UserID
+	env.Time = legacy.Timestamp
+	return nil
+}
@@ -0,0 +1,198 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lucas/shared/codec"
+	"github.com/lucas/shared/models"
+)
+
+func TestSchemasAreCompatible(t *testing.T) {
+	if err := Schemas.Check(); err != nil {
+		t.Fatalf("Schemas.Check() = %v", err)
+	}
+}
+
+type accountV1 struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+type accountV2 struct {
+	Email  string `json:"email"`
+	Name   string `json:"name"`
+	Locale string `json:"locale"`
+}
+
+type accountWithoutName struct {
+	Email string `json:"email"`
+}
+
+type accountWithNumericName struct {
+	Email string `json:"email"`
+	Name  int    `json:"name"`
+}
+
+func addLocale(data json.RawMessage) (json.RawMessage, error) {
+	var account map[string]any
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, err
+	}
+	account["locale"] = "en"
+	return json.Marshal(account)
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		register func(r *Registry)
+		problem  string // Empty when the registry is compatible
+	}{
+		{
+			name: "added field with upcaster",
+			register: func(r *Registry) {
+				Register[accountV1](r, "account.created", 1)
+				Register[accountV2](r, "account.created", 2)
+				r.RegisterUpcaster("account.created", 1, addLocale)
+			},
+		},
+		{
+			name: "removed field",
+			register: func(r *Registry) {
+				Register[accountV1](r, "account.created", 1)
+				Register[accountWithoutName](r, "account.created", 2)
+				r.RegisterUpcaster("account.created", 1, addLocale)
+			},
+			problem: "account.created: v2 removes field name",
+		},
+		{
+			name: "retyped field",
+			register: func(r *Registry) {
+				Register[accountV1](r, "account.created", 1)
+				Register[accountWithNumericName](r, "account.created", 2)
+				r.RegisterUpcaster("account.created", 1, addLocale)
+			},
+			problem: "account.created: v2 changes field name from string to number",
+		},
+		{
+			name: "version gap",
+			register: func(r *Registry) {
+				Register[accountV1](r, "account.created", 1)
+				Register[accountV2](r, "account.created", 3)
+				r.RegisterUpcaster("account.created", 1, addLocale)
+			},
+			problem: "account.created: v2 is not registered",
+		},
+		{
+			name: "missing upcaster",
+			register: func(r *Registry) {
+				Register[accountV1](r, "account.created", 1)
+				Register[accountV2](r, "account.created", 2)
+			},
+			problem: "account.created: no upcaster from v1 to v2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			tt.register(r)
+
+			err := r.Check()
+			switch {
+			case tt.problem == "" && err != nil:
+				t.Fatalf("Check() = %v, want no error", err)
+			case tt.problem != "" && err == nil:
+				t.Fatalf("Check() = nil, want %q", tt.problem)
+			case tt.problem != "" && !strings.Contains(err.Error(), tt.problem):
+				t.Fatalf("Check() = %v, want it to contain %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestDecodeUpcastsOlderVersions(t *testing.T) {
+	r := NewRegistry()
+	Register[accountV1](r, "account.created", 1)
+	Register[accountV2](r, "account.created", 2)
+	r.RegisterUpcaster("account.created", 1, addLocale)
+
+	value, err := json.Marshal(Envelope{
+		ID:            "event-1",
+		Type:          "account.created",
+		SchemaVersion: 1,
+		Subject:       "42",
+		Data:          json.RawMessage(`{"email":"ada@example.com","name":"Ada"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := r.Decode(codec.ContentTypeJSON, value)
+	if err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	if env.SchemaVersion != 2 {
+		t.Errorf("SchemaVersion = %d, want 2", env.SchemaVersion)
+	}
+
+	account, err := DataAs[accountV2](env)
+	if err != nil {
+		t.Fatalf("DataAs() = %v", err)
+	}
+	want := accountV2{Email: "ada@example.com", Name: "Ada", Locale: "en"}
+	if account != want {
+		t.Errorf("payload = %+v, want %+v", account, want)
+	}
+}
+
+func TestDecodeUpgradesLegacyEvents(t *testing.T) {
+	// As published by user-service before the envelope existed
+	value := []byte(`{
+		"type": "user.password_reset_requested",
+		"user_id": "42",
+		"data": {"email": "ada@example.com", "name": "Ada", "token": "abc", "expires_at": "2024-05-01T10:00:00Z"},
+		"timestamp": "2024-05-01T09:00:00Z"
+	}`)
+
+	env, err := Schemas.Decode(codec.ContentTypeJSON, value)
+	if err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	if env.Type != models.EventPasswordResetRequested || env.SchemaVersion != 1 || env.Subject != "42" {
+		t.Errorf("envelope = %s v%d about %q, want %s v1 about \"42\"", env.Type, env.SchemaVersion, env.Subject, models.EventPasswordResetRequested)
+	}
+	if want := time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC); !env.Time.Equal(want) {
+		t.Errorf("Time = %v, want %v", env.Time, want)
+	}
+
+	data, err := DataAs[models.TokenEventData](env)
+	if err != nil {
+		t.Fatalf("DataAs() = %v", err)
+	}
+	if data.Email != "ada@example.com" || data.Token != "abc" {
+		t.Errorf("payload = %+v, want the legacy data", data)
+	}
+}
+
+func TestNewEnvelopeRequiresLatestPayload(t *testing.T) {
+	r := NewRegistry()
+	Register[accountV1](r, "account.created", 1)
+	Register[accountV2](r, "account.created", 2)
+
+	if _, err := r.NewEnvelope(context.Background(), "account.created", "test", "42", accountV1{}); err == nil {
+		t.Error("NewEnvelope() with a v1 payload succeeded, want an error")
+	}
+
+	env, err := r.NewEnvelope(context.Background(), "account.created", "test", "42", accountV2{Email: "ada@example.com"})
+	if err != nil {
+		t.Fatalf("NewEnvelope() = %v", err)
+	}
+	if env.SchemaVersion != 2 || env.Source != "test" || env.Subject != "42" {
+		t.Errorf("envelope = %+v", env)
+	}
+}
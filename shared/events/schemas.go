@@ -0,0 +1,18 @@
+package events
+
+import "github.com/lucas/shared/models"
+
+// TopicUserEvents carries the user lifecycle events published by user-service
+const TopicUserEvents = "user-events"
+
+// Schemas registers the payload of every event published on the platform.
+// To change a payload, register the new struct as the next version along with
+// an upcaster from the previous one; Check tells whether the change is safe.
+var Schemas = NewRegistry()
+
+func init() {
+	Register[models.TokenEventData](Schemas, models.EventUserRegistered, 1)
+	Register[models.TokenEventData](Schemas, models.EventEmailChangeRequested, 1)
+	Register[models.TokenEventData](Schemas, models.EventPasswordResetRequested, 1)
+	Register[models.UserDeletedData](Schemas, models.EventUserDeleted, 1)
+}
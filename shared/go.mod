@@ -3,9 +3,13 @@ module github.com/lucas/shared
 go 1.22.2
 
 require (
+	github.com/bufbuild/protocompile v0.13.0
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/golang-migrate/migrate/v4 v4.17.0
+	github.com/google/uuid v1.6.0
 	github.com/lib/pq v1.10.9
+	github.com/segmentio/kafka-go v0.4.48
+	google.golang.org/protobuf v1.34.1
 )
 
 require (
@@ -13,5 +17,8 @@ require (
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
+	github.com/klauspost/compress v1.15.11 // indirect
+	github.com/pierrec/lz4/v4 v4.1.16 // indirect
 	go.uber.org/atomic v1.7.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
 )
@@ -0,0 +1,64 @@
+// Package health answers the gateway's service pings.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/lucas/shared/consumer"
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	TopicPing = "service-ping"
+	TopicPong = "service-pong"
+)
+
+// ServePings answers pings meant for service until ctx is cancelled. group
+// must be used by no other consumer of the service, so every instance group
+// gets every ping.
+func ServePings(ctx context.Context, broker, service, group string) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{broker},
+		Topic:       TopicPing,
+		GroupID:     group,
+		StartOffset: kafka.LastOffset, // Nobody waits for the answer to an old ping
+		MaxWait:     5 * time.Second,
+	})
+
+	writer := &kafka.Writer{
+		Addr:     kafka.TCP(broker),
+		Topic:    TopicPong,
+		Balancer: &kafka.LeastBytes{},
+	}
+	defer writer.Close()
+
+	handle := func(ctx context.Context, message kafka.Message) ([]byte, error) {
+		// Pings are either addressed to a service or broadcast
+		if string(message.Key) != service && string(message.Value) != "ping" {
+			return nil, nil
+		}
+
+		pong, _ := json.Marshal(map[string]string{
+			"status":    "healthy",
+			"service":   service,
+			"timestamp": time.Now().Format(time.RFC3339),
+		})
+
+		writeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		err := writer.WriteMessages(writeCtx, kafka.Message{Key: []byte(service), Value: pong})
+		if err != nil {
+			// A late pong is useless, so the ping isn't retried
+			log.Printf("Failed to send pong for %s: %v", service, err)
+			return nil, nil
+		}
+
+		log.Printf("Sent pong for %s", service)
+		return nil, nil
+	}
+
+	consumer.New(service+" pings", reader, handle).Run(ctx)
+}
@@ -0,0 +1,106 @@
+package models
+
+import "net/http"
+
+// Error codes carried in RPC error replies and in error bodies
+// returned to HTTP clients. They are part of the API: never rename one.
+const (
+	ErrCodeInvalidRequest       = "invalid_request"
+	ErrCodeInvalidEmail         = "invalid_email"
+	ErrCodeInvalidPassword      = "invalid_password"
+	ErrCodeInvalidName          = "invalid_name"
+	ErrCodeInvalidMFACode       = "invalid_mfa_code"
+	ErrCodeMFANotEnrolled       = "mfa_not_enrolled"
+	ErrCodeInvalidAPIKeyRequest = "invalid_api_key_request"
+	ErrCodeTooManyAPIKeys       = "too_many_api_keys"
+	ErrCodeInvalidOAuthState    = "invalid_oauth_state"
+	ErrCodeOAuthEmailMissing    = "oauth_email_missing"
+	ErrCodeInvalidRole          = "invalid_role"
+	ErrCodeCannotModifySelf     = "cannot_modify_self"
+
+	ErrCodeUnauthenticated    = "unauthenticated"
+	ErrCodeInvalidCredentials = "invalid_credentials"
+	ErrCodeInvalidToken       = "invalid_token"
+	ErrCodeInvalidAPIKey      = "invalid_api_key"
+
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeMFARequired      = "mfa_required"
+	ErrCodeEmailNotVerified = "email_not_verified"
+	ErrCodeAccountDisabled  = "account_disabled"
+
+	ErrCodeUserNotFound            = "user_not_found"
+	ErrCodeSessionNotFound         = "session_not_found"
+	ErrCodeAPIKeyNotFound          = "api_key_not_found"
+	ErrCodeUnknownIdentityProvider = "unknown_identity_provider"
+	ErrCodeDeadLetterNotFound      = "dead_letter_not_found"
+
+	ErrCodeEmailInUse            = "email_in_use"
+	ErrCodeMFAAlreadyEnabled     = "mfa_already_enabled"
+	ErrCodeIdentityAlreadyLinked = "identity_already_linked"
+
+	ErrCodeTooManyLoginAttempts   = "too_many_login_attempts"
+	ErrCodeTooManyPasswordResets  = "too_many_password_resets"
+	ErrCodeUnknownAction          = "unknown_action"
+	ErrCodeTimeout                = "timeout"
+	ErrCodeInternal               = "internal"
+	ErrCodeIdentityProviderFailed = "identity_provider_failed"
+	ErrCodeServiceUnavailable     = "service_unavailable"
+)
+
+var errorCodeStatus = map[string]int{
+	ErrCodeInvalidRequest:       http.StatusBadRequest,
+	ErrCodeInvalidEmail:         http.StatusBadRequest,
+	ErrCodeInvalidPassword:      http.StatusBadRequest,
+	ErrCodeInvalidName:          http.StatusBadRequest,
+	ErrCodeInvalidMFACode:       http.StatusBadRequest,
+	ErrCodeMFANotEnrolled:       http.StatusBadRequest,
+	ErrCodeInvalidAPIKeyRequest: http.StatusBadRequest,
+	ErrCodeTooManyAPIKeys:       http.StatusBadRequest,
+	ErrCodeInvalidOAuthState:    http.StatusBadRequest,
+	ErrCodeOAuthEmailMissing:    http.StatusBadRequest,
+	ErrCodeInvalidRole:          http.StatusBadRequest,
+	ErrCodeCannotModifySelf:     http.StatusBadRequest,
+
+	ErrCodeUnauthenticated:    http.StatusUnauthorized,
+	ErrCodeInvalidCredentials: http.StatusUnauthorized,
+	ErrCodeInvalidToken:       http.StatusUnauthorized,
+	ErrCodeInvalidAPIKey:      http.StatusUnauthorized,
+
+	ErrCodeForbidden:        http.StatusForbidden,
+	ErrCodeMFARequired:      http.StatusForbidden,
+	ErrCodeEmailNotVerified: http.StatusForbidden,
+	ErrCodeAccountDisabled:  http.StatusForbidden,
+
+	ErrCodeUserNotFound:            http.StatusNotFound,
+	ErrCodeSessionNotFound:         http.StatusNotFound,
+	ErrCodeAPIKeyNotFound:          http.StatusNotFound,
+	ErrCodeUnknownIdentityProvider: http.StatusNotFound,
+	ErrCodeDeadLetterNotFound:      http.StatusNotFound,
+
+	ErrCodeEmailInUse:            http.StatusConflict,
+	ErrCodeMFAAlreadyEnabled:     http.StatusConflict,
+	ErrCodeIdentityAlreadyLinked: http.StatusConflict,
+
+	ErrCodeTooManyLoginAttempts:   http.StatusTooManyRequests,
+	ErrCodeTooManyPasswordResets:  http.StatusTooManyRequests,
+	ErrCodeUnknownAction:          http.StatusNotImplemented,
+	ErrCodeTimeout:                http.StatusGatewayTimeout,
+	ErrCodeInternal:               http.StatusInternalServerError,
+	ErrCodeIdentityProviderFailed: http.StatusBadGateway,
+	ErrCodeServiceUnavailable:     http.StatusServiceUnavailable,
+}
+
+// StatusForErrorCode returns the HTTP status an error code is answered with.
+// Unknown codes are treated as internal errors.
+func StatusForErrorCode(code string) int {
+	if status, ok := errorCodeStatus[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorResponse is the body of every error the gateway answers with
+type ErrorResponse struct {
+	Error string `json:"error"` // Human readable, may change
+	Code  string `json:"code"`  // One of the ErrCode constants
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Topics of the personal data export fan-out. Requests are broadcast to every
+// service holding user data; fragments go back to the user-service instance
+// named in ReplyTo.
+const (
+	TopicDataExportRequests  = "user-data-export-requests"
+	TopicDataExportFragments = "user-data-export-fragments"
+)
+
+type DataExportRequest struct {
+	ExportID    string    `json:"export_id"`
+	UserID      string    `json:"user_id"`
+	ReplyTo     string    `json:"reply_to"` // Instance that assembles the archive
+	RequestedAt time.Time `json:"requested_at"`
+}
+
+// DataExportFragment is one service's part of an export archive.
+type DataExportFragment struct {
+	ExportID string `json:"export_id"`
+	ReplyTo  string `json:"reply_to"`
+	Service  string `json:"service"`
+	Data     any    `json:"data,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
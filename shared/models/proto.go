@@ -0,0 +1,132 @@
+package models
+
+import (
+	"github.com/lucas/shared/codec"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf encodings of the payloads in shared/proto/user.proto
+
+func (r RegisterRequest) MarshalProto() []byte {
+	var b []byte
+	b = codec.AppendString(b, 1, r.Email)
+	b = codec.AppendString(b, 2, r.Password)
+	b = codec.AppendString(b, 3, r.Name)
+	return b
+}
+
+func (r *RegisterRequest) UnmarshalProto(data []byte) error {
+	return codec.ReadFields(data, func(num protowire.Number, field codec.Field) error {
+		switch num {
+		case 1:
+			r.Email = field.String()
+		case 2:
+			r.Password = field.String()
+		case 3:
+			r.Name = field.String()
+		}
+		return nil
+	})
+}
+
+func (r LoginRequest) MarshalProto() []byte {
+	var b []byte
+	b = codec.AppendString(b, 1, r.Email)
+	b = codec.AppendString(b, 2, r.Password)
+	return b
+}
+
+func (r *LoginRequest) UnmarshalProto(data []byte) error {
+	return codec.ReadFields(data, func(num protowire.Number, field codec.Field) error {
+		switch num {
+		case 1:
+			r.Email = field.String()
+		case 2:
+			r.Password = field.String()
+		}
+		return nil
+	})
+}
+
+func (r RefreshRequest) MarshalProto() []byte {
+	return codec.AppendString(nil, 1, r.RefreshToken)
+}
+
+func (r *RefreshRequest) UnmarshalProto(data []byte) error {
+	return codec.ReadFields(data, func(num protowire.Number, field codec.Field) error {
+		if num == 1 {
+			r.RefreshToken = field.String()
+		}
+		return nil
+	})
+}
+
+func (r LogoutRequest) MarshalProto() []byte {
+	var b []byte
+	b = codec.AppendString(b, 1, r.UserID)
+	b = codec.AppendString(b, 2, r.SessionID)
+	b = codec.AppendString(b, 3, r.TokenID)
+	b = codec.AppendTime(b, 4, r.TokenExpiresAt)
+	b = codec.AppendBool(b, 5, r.AllDevices)
+	return b
+}
+
+func (r *LogoutRequest) UnmarshalProto(data []byte) error {
+	return codec.ReadFields(data, func(num protowire.Number, field codec.Field) error {
+		var err error
+		switch num {
+		case 1:
+			r.UserID = field.String()
+		case 2:
+			r.SessionID = field.String()
+		case 3:
+			r.TokenID = field.String()
+		case 4:
+			r.TokenExpiresAt, err = field.Time()
+		case 5:
+			r.AllDevices = field.Bool()
+		}
+		return err
+	})
+}
+
+func (r AuthenticateAPIKeyRequest) MarshalProto() []byte {
+	return codec.AppendString(nil, 1, r.Key)
+}
+
+func (r *AuthenticateAPIKeyRequest) UnmarshalProto(data []byte) error {
+	return codec.ReadFields(data, func(num protowire.Number, field codec.Field) error {
+		if num == 1 {
+			r.Key = field.String()
+		}
+		return nil
+	})
+}
+
+func (i APIKeyIdentity) MarshalProto() []byte {
+	var b []byte
+	b = codec.AppendString(b, 1, i.KeyID)
+	b = codec.AppendString(b, 2, i.UserID)
+	b = codec.AppendString(b, 3, i.Email)
+	b = codec.AppendStrings(b, 4, i.Roles)
+	b = codec.AppendStrings(b, 5, i.Permissions)
+	return b
+}
+
+func (i *APIKeyIdentity) UnmarshalProto(data []byte) error {
+	return codec.ReadFields(data, func(num protowire.Number, field codec.Field) error {
+		switch num {
+		case 1:
+			i.KeyID = field.String()
+		case 2:
+			i.UserID = field.String()
+		case 3:
+			i.Email = field.String()
+		case 4:
+			i.Roles = append(i.Roles, field.String())
+		case 5:
+			i.Permissions = append(i.Permissions, field.String())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lucas/shared/proto/prototest"
+)
+
+func TestProtoMatchesSchema(t *testing.T) {
+	tests := []struct {
+		message string
+		check   func(t *testing.T, name string)
+	}{
+		{"RegisterRequest", func(t *testing.T, name string) {
+			prototest.RoundTrip(t, prototest.Message(t, "user.proto", name),
+				RegisterRequest{Email: "ada@example.com", Password: "hunter2", Name: "Ada"},
+				`{"email": "ada@example.com", "password": "hunter2", "name": "Ada"}`)
+		}},
+		{"LoginRequest", func(t *testing.T, name string) {
+			prototest.RoundTrip(t, prototest.Message(t, "user.proto", name),
+				LoginRequest{Email: "ada@example.com", Password: "hunter2"},
+				`{"email": "ada@example.com", "password": "hunter2"}`)
+		}},
+		{"RefreshRequest", func(t *testing.T, name string) {
+			prototest.RoundTrip(t, prototest.Message(t, "user.proto", name),
+				RefreshRequest{RefreshToken: "refresh"},
+				`{"refresh_token": "refresh"}`)
+		}},
+		{"LogoutRequest", func(t *testing.T, name string) {
+			prototest.RoundTrip(t, prototest.Message(t, "user.proto", name),
+				LogoutRequest{
+					UserID:         "42",
+					SessionID:      "session",
+					TokenID:        "token",
+					TokenExpiresAt: time.Date(2024, 5, 1, 10, 0, 0, 500, time.UTC),
+					AllDevices:     true,
+				},
+				`{"user_id": "42", "session_id": "session", "token_id": "token",
+				  "token_expires_at": "2024-05-01T10:00:00.000000500Z", "all_devices": true}`)
+		}},
+		{"AuthenticateAPIKeyRequest", func(t *testing.T, name string) {
+			prototest.RoundTrip(t, prototest.Message(t, "user.proto", name),
+				AuthenticateAPIKeyRequest{Key: "key"},
+				`{"key": "key"}`)
+		}},
+		{"APIKeyIdentity", func(t *testing.T, name string) {
+			prototest.RoundTrip(t, prototest.Message(t, "user.proto", name),
+				APIKeyIdentity{
+					KeyID:       "key",
+					UserID:      "42",
+					Email:       "ada@example.com",
+					Roles:       []string{"admin", "user"},
+					Permissions: []string{"users:read", "users:write"},
+				},
+				`{"key_id": "key", "user_id": "42", "email": "ada@example.com",
+				  "roles": ["admin", "user"], "permissions": ["users:read", "users:write"]}`)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			tt.check(t, tt.message)
+		})
+	}
+}
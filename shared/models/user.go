@@ -13,16 +13,193 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
-type UserServiceMessage struct {
-	CorrelationID string    `json:"correlation_id"`
-	Action        string    `json:"action"`
-	Data          any       `json:"data"`
-	Timestamp     time.Time `json:"timestamp"`
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
-type UserServiceResponse struct {
-	CorrelationID string `json:"correlation_id"` // To track the request
-	StatusCode    int    `json:"status_code"`
-	Data          any    `json:"data"`
-	Error         string `json:"error,omitempty"`
+// LogoutRequest identifies the token being logged out. Everything but
+// AllDevices is filled by the gateway from the verified token.
+type LogoutRequest struct {
+	UserID         string    `json:"user_id"`
+	SessionID      string    `json:"session_id"`
+	TokenID        string    `json:"token_id"`
+	TokenExpiresAt time.Time `json:"token_expires_at"`
+	AllDevices     bool      `json:"all_devices"`
 }
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type EnrollMFARequest struct {
+	UserID string `json:"user_id"`
+}
+
+type ConfirmMFARequest struct {
+	UserID string `json:"user_id"`
+	Code   string `json:"code" binding:"required"`
+}
+
+type VerifyMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"` // TOTP code or recovery code
+}
+
+type OAuthStartRequest struct {
+	Provider string `json:"provider"`
+}
+
+// OAuthCallbackRequest carries the query parameters the identity provider redirected back with
+type OAuthCallbackRequest struct {
+	Provider string `json:"provider"`
+	Code     string `json:"code" form:"code" binding:"required"`
+	State    string `json:"state" form:"state" binding:"required"`
+}
+
+// LinkIdentityRequest starts linking an identity provider to the signed-in
+// user, who confirms with their password
+type LinkIdentityRequest struct {
+	UserID   string `json:"user_id"`
+	Provider string `json:"provider"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Admin requests carry the acting admin as ActorID, filled by the gateway
+// from the verified token, for the audit trail.
+
+type UnlockAccountRequest struct {
+	ActorID string `json:"actor_id"`
+	Email   string `json:"email" binding:"required"`
+}
+
+type SearchUsersRequest struct {
+	Query  string `json:"query" form:"q"` // Matched against email and name
+	Limit  int    `json:"limit" form:"limit"`
+	Offset int    `json:"offset" form:"offset"`
+}
+
+// AdminUserRequest names the user an admin action applies to
+type AdminUserRequest struct {
+	ActorID string `json:"actor_id"`
+	UserID  string `json:"user_id"`
+}
+
+type DisableUserRequest struct {
+	ActorID string `json:"actor_id"`
+	UserID  string `json:"user_id"`
+	Reason  string `json:"reason"`
+}
+
+type SetUserRolesRequest struct {
+	ActorID string   `json:"actor_id"`
+	UserID  string   `json:"user_id"`
+	Roles   []string `json:"roles" binding:"required"`
+}
+
+type ListAuditRequest struct {
+	UserID string `json:"user_id" form:"user_id"` // Only entries about this user when set
+	Limit  int    `json:"limit" form:"limit"`
+	Offset int    `json:"offset" form:"offset"`
+}
+
+// ListSessionsRequest is filled by the gateway from the verified token;
+// SessionID marks the caller's own session in the listing.
+type ListSessionsRequest struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+}
+
+type RevokeSessionRequest struct {
+	UserID    string `json:"user_id"`
+	SessionID string `json:"session_id"`
+}
+
+type CreateAPIKeyRequest struct {
+	UserID    string     `json:"user_id"`
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"` // Permissions the key may use
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type ListAPIKeysRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type RevokeAPIKeyRequest struct {
+	UserID string `json:"user_id"`
+	KeyID  string `json:"key_id"`
+}
+
+type AuthenticateAPIKeyRequest struct {
+	Key string `json:"key"`
+}
+
+// APIKeyIdentity is who an API key acts as, resolved by user-service for the gateway
+type APIKeyIdentity struct {
+	KeyID       string   `json:"key_id"`
+	UserID      string   `json:"user_id"`
+	Email       string   `json:"email"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"` // The key's scopes the user still holds
+}
+
+type GetProfileRequest struct {
+	UserID string `json:"user_id"`
+}
+
+type UpdateProfileRequest struct {
+	UserID string  `json:"user_id"`
+	Name   *string `json:"name,omitempty"`
+	Email  *string `json:"email,omitempty"`
+}
+
+type ChangePasswordRequest struct {
+	UserID          string `json:"user_id"`
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type ExportDataRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// DeleteAccountRequest asks for the password again so a stolen session alone can't delete the account
+type DeleteAccountRequest struct {
+	UserID   string `json:"user_id"`
+	Password string `json:"password" binding:"required"`
+}
+
+// User lifecycle events published by user-service on the user-events topic.
+// Their payloads are registered in events.Schemas.
+const (
+	EventUserRegistered         = "user.registered"
+	EventEmailChangeRequested   = "user.email_change_requested"
+	EventPasswordResetRequested = "user.password_reset_requested"
+	EventUserDeleted            = "user.deleted"
+)
+
+// TokenEventData is the payload of the events carrying a single-use token to
+// email as a link: user.registered, user.email_change_requested and
+// user.password_reset_requested.
+type TokenEventData struct {
+	Email     string    `json:"email"`
+	Name      string    `json:"name"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UserDeletedData is the payload of user.deleted. The user is the event's subject.
+type UserDeletedData struct{}
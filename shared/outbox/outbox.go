@@ -0,0 +1,258 @@
+// Package outbox publishes Kafka messages that a service writes to its outbox
+// table in the same transaction as the change they describe, so a crash
+// between committing the change and publishing can't lose them.
+//
+// Once published, a message's value is erased; the row stays for a day as a
+// record of what was sent.
+//
+// Delivery is at least once: a message is sent again when the relay stops
+// between publishing it and marking it sent, so consumers must tolerate
+// duplicates. Messages are published in the order they were written; only a
+// message Kafka rejected can end up behind later ones, when it is retried.
+//
+// Services using it create the table with:
+//
+//	CREATE TABLE outbox (
+//	    id BIGSERIAL PRIMARY KEY,
+//	    topic VARCHAR(255) NOT NULL,
+//	    key TEXT NOT NULL DEFAULT '',
+//	    value BYTEA NOT NULL,
+//	    headers JSONB NOT NULL DEFAULT '{}',
+//	    attempts INTEGER NOT NULL DEFAULT 0,
+//	    last_error TEXT,
+//	    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+//	    sent_at TIMESTAMP
+//	);
+//	CREATE INDEX idx_outbox_pending ON outbox(id) WHERE sent_at IS NULL;
+//	CREATE INDEX idx_outbox_sent_at ON outbox(sent_at) WHERE sent_at IS NOT NULL;
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/segmentio/kafka-go"
+)
+
+// relayLock is the advisory lock held while relaying, so that with several
+// replicas only one publishes at a time and messages keep their order
+const relayLock = 7_462_110_352
+
+// Message is a Kafka message waiting in the outbox.
+type Message struct {
+	Topic   string
+	Key     string
+	Value   []byte
+	Headers map[string]string
+}
+
+// Execer is satisfied by both *sql.DB and *sql.Tx
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Enqueue writes messages to the outbox. Pass the transaction of the change
+// they describe so they are only sent if it commits.
+func Enqueue(ctx context.Context, db Execer, messages ...Message) error {
+	for _, message := range messages {
+		headers, err := json.Marshal(message.Headers)
+		if err != nil {
+			return err
+		}
+		if message.Headers == nil {
+			headers = []byte("{}")
+		}
+
+		_, err = db.ExecContext(ctx,
+			`INSERT INTO outbox (topic, key, value, headers) VALUES ($1, $2, $3, $4)`,
+			message.Topic, message.Key, message.Value, headers,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to write %s message to the outbox: %w", message.Topic, err)
+		}
+	}
+	return nil
+}
+
+// Relay publishes the outbox to Kafka.
+type Relay struct {
+	db        *sql.DB
+	writer    *kafka.Writer
+	batchSize int
+	interval  time.Duration // Between polls when the outbox is empty
+	retention time.Duration // How long the record of sent messages is kept
+}
+
+func NewRelay(db *sql.DB, broker string) *Relay {
+	return &Relay{
+		db: db,
+		// No fixed topic: each message names its own
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(broker),
+			Balancer:     &kafka.Hash{}, // Same key, same partition, so per-key order holds
+			RequiredAcks: kafka.RequireAll,
+			BatchTimeout: 10 * time.Millisecond,
+		},
+		batchSize: 100,
+		interval:  500 * time.Millisecond,
+		retention: 24 * time.Hour,
+	}
+}
+
+// Run publishes pending messages until ctx is cancelled. Failed publishes are
+// retried with exponential backoff, up to a minute apart.
+func (r *Relay) Run(ctx context.Context) {
+	defer r.writer.Close()
+
+	log.Printf("Outbox relay started")
+
+	failures := 0
+	lastCleanup := time.Time{}
+	for {
+		sent, err := r.relayBatch(ctx)
+		wait := r.interval
+		switch {
+		case err != nil:
+			if ctx.Err() != nil {
+				break
+			}
+			failures++
+			wait = min(time.Duration(1<<min(failures, 6))*time.Second, time.Minute)
+			log.Printf("Failed to relay outbox (attempt %d), retrying in %s: %v", failures, wait, err)
+		case sent == r.batchSize:
+			// More are probably waiting
+			failures = 0
+			wait = 0
+		default:
+			failures = 0
+		}
+
+		if time.Since(lastCleanup) > time.Hour {
+			r.cleanup(ctx)
+			lastCleanup = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("Outbox relay stopped")
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// relayBatch publishes the oldest pending messages and marks them sent.
+func (r *Relay) relayBatch(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock($1)`, relayLock).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil // Another replica is relaying
+	}
+
+	ids, messages, err := r.pending(ctx, tx)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+
+	sent, failed := ids, []int64(nil)
+	publishErr := r.writer.WriteMessages(ctx, messages...)
+	if publishErr != nil {
+		sent = nil
+		var writeErrs kafka.WriteErrors
+		for i, id := range ids {
+			if errors.As(publishErr, &writeErrs) && writeErrs[i] == nil {
+				sent = append(sent, id)
+			} else {
+				failed = append(failed, id)
+			}
+		}
+	}
+
+	// Payloads can carry secrets such as single-use tokens, so they are
+	// dropped as soon as they are published
+	if len(sent) > 0 {
+		if _, err := tx.ExecContext(ctx, `UPDATE outbox SET sent_at = NOW(), attempts = attempts + 1, value = '' WHERE id = ANY($1)`, pq.Array(sent)); err != nil {
+			return 0, err
+		}
+	}
+	if len(failed) > 0 {
+		_, err := tx.ExecContext(ctx, `UPDATE outbox SET attempts = attempts + 1, last_error = $2 WHERE id = ANY($1)`, pq.Array(failed), publishErr.Error())
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	if publishErr != nil {
+		return len(sent), fmt.Errorf("%d of %d messages not published: %w", len(failed), len(ids), publishErr)
+	}
+	return len(sent), nil
+}
+
+func (r *Relay) pending(ctx context.Context, tx *sql.Tx) ([]int64, []kafka.Message, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, topic, key, value, headers FROM outbox
+		WHERE sent_at IS NULL
+		ORDER BY id
+		LIMIT $1
+	`, r.batchSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	var messages []kafka.Message
+	for rows.Next() {
+		var id int64
+		var message kafka.Message
+		var key string
+		var rawHeaders []byte
+		if err := rows.Scan(&id, &message.Topic, &key, &message.Value, &rawHeaders); err != nil {
+			return nil, nil, err
+		}
+
+		var headers map[string]string
+		if err := json.Unmarshal(rawHeaders, &headers); err != nil {
+			return nil, nil, fmt.Errorf("invalid headers in outbox message %d: %w", id, err)
+		}
+		for name, value := range headers {
+			message.Headers = append(message.Headers, kafka.Header{Key: name, Value: []byte(value)})
+		}
+		if key != "" {
+			message.Key = []byte(key)
+		}
+
+		ids = append(ids, id)
+		messages = append(messages, message)
+	}
+	return ids, messages, rows.Err()
+}
+
+// cleanup deletes the records of messages sent longer ago than the retention.
+func (r *Relay) cleanup(ctx context.Context) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM outbox WHERE sent_at < NOW() - make_interval(secs => $1)`, r.retention.Seconds())
+	if err != nil {
+		log.Printf("Failed to clean up the outbox: %v", err)
+		return
+	}
+	if deleted, _ := result.RowsAffected(); deleted > 0 {
+		log.Printf("Deleted %d sent messages from the outbox", deleted)
+	}
+}
@@ -0,0 +1,108 @@
+// Package privacy lets services take part in personal data exports and
+// account deletions initiated by user-service.
+package privacy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/lucas/shared/codec"
+	"github.com/lucas/shared/consumer"
+	"github.com/lucas/shared/events"
+	"github.com/lucas/shared/models"
+	"github.com/segmentio/kafka-go"
+)
+
+// ExportFunc returns everything the service holds about userID, ready to be
+// marshalled to JSON.
+type ExportFunc func(ctx context.Context, userID string) (any, error)
+
+// PurgeFunc deletes or anonymises everything the service holds about userID.
+// It must be idempotent: the same deletion may be delivered more than once.
+type PurgeFunc func(ctx context.Context, userID string) error
+
+// ServeDataExports answers export requests with the service's fragment until
+// ctx is cancelled. Every service uses its own consumer group so each one
+// receives every request.
+func ServeDataExports(ctx context.Context, broker, service string, export ExportFunc) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{broker},
+		Topic:       models.TopicDataExportRequests,
+		GroupID:     service + "-data-exports",
+		StartOffset: kafka.LastOffset, // Old requests have no one waiting any more
+	})
+
+	writer := &kafka.Writer{
+		Addr:     kafka.TCP(broker),
+		Topic:    models.TopicDataExportFragments,
+		Balancer: &kafka.LeastBytes{},
+	}
+	defer writer.Close()
+
+	// The collector waits for the fragments, so failures are reported in them
+	// rather than retried
+	handle := func(ctx context.Context, message kafka.Message) ([]byte, error) {
+		var request models.DataExportRequest
+		if err := json.Unmarshal(message.Value, &request); err != nil {
+			log.Printf("Failed to unmarshal data export request: %v", err)
+			return nil, nil
+		}
+
+		fragment := models.DataExportFragment{
+			ExportID: request.ExportID,
+			ReplyTo:  request.ReplyTo,
+			Service:  service,
+		}
+		data, err := export(ctx, request.UserID)
+		if err != nil {
+			log.Printf("Failed to export data of user %s: %v", request.UserID, err)
+			fragment.Error = "export failed"
+		} else {
+			fragment.Data = data
+		}
+
+		value, _ := json.Marshal(fragment)
+		if err := writer.WriteMessages(ctx, kafka.Message{Key: []byte(request.ExportID), Value: value}); err != nil {
+			log.Printf("Failed to send data export fragment %s: %v", request.ExportID, err)
+		}
+		return nil, nil
+	}
+
+	consumer.New("data exports of "+service, reader, handle).
+		WithConcurrency(4).
+		Run(ctx)
+}
+
+// ConsumeUserDeletions calls purge for every user.deleted event until ctx is
+// cancelled. A failed purge is retried from the retry topics of the user
+// events, so data isn't left behind while other users' deletions go on.
+func ConsumeUserDeletions(ctx context.Context, broker, service string, purge PurgeFunc) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{broker},
+		Topic:       events.TopicUserEvents,
+		GroupID:     service + "-user-deletions",
+		StartOffset: kafka.FirstOffset,
+	})
+
+	handle := func(ctx context.Context, message kafka.Message) ([]byte, error) {
+		event, err := events.Schemas.Decode(codec.ContentTypeOf(message), message.Value)
+		if err != nil {
+			return nil, consumer.Permanent(fmt.Errorf("failed to decode user event: %w", err))
+		}
+		if event.Type != models.EventUserDeleted {
+			return nil, nil
+		}
+
+		if err := purge(ctx, event.Subject); err != nil {
+			return nil, fmt.Errorf("failed to purge data of user %s: %w", event.Subject, err)
+		}
+		log.Printf("Purged data of deleted user %s", event.Subject)
+		return nil, nil
+	}
+
+	consumer.New("user deletions of "+service, reader, handle).
+		WithRetryTopics(consumer.DefaultRetryDelays...).
+		Run(ctx)
+}
@@ -0,0 +1,92 @@
+// Package prototest checks the hand-written protobuf encodings in shared
+// against the schemas in shared/proto, so a field number or type that drifts
+// from the .proto file fails a test instead of corrupting messages between
+// JSON and protobuf peers.
+package prototest
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/lucas/shared/codec"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Message returns the descriptor of message name in file, a path relative
+// to shared/proto.
+func Message(t testing.TB, file, name string) protoreflect.MessageDescriptor {
+	t.Helper()
+
+	_, self, _, _ := runtime.Caller(0)
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			ImportPaths: []string{filepath.Join(filepath.Dir(self), "..")},
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), file)
+	if err != nil {
+		t.Fatalf("failed to compile %s: %v", file, err)
+	}
+
+	desc := files[0].Messages().ByName(protoreflect.Name(name))
+	if desc == nil {
+		t.Fatalf("%s has no message %s", file, name)
+	}
+	return desc
+}
+
+// Value is a Go type with a hand-written protobuf encoding.
+type Value[T any] interface {
+	*T
+	codec.ProtoMarshaler
+	codec.ProtoUnmarshaler
+}
+
+// RoundTrip checks that v encodes to the message described by desc with the
+// field values in want, given in protojson form, and that want encoded by
+// the protobuf runtime decodes back into v. want must set every field of
+// desc, so a field the Go type leaves out is caught too.
+func RoundTrip[T any, PT Value[T]](t *testing.T, desc protoreflect.MessageDescriptor, v T, want string) {
+	t.Helper()
+
+	expected := dynamicpb.NewMessage(desc)
+	if err := protojson.Unmarshal([]byte(want), expected); err != nil {
+		t.Fatalf("invalid %s %s: %v", desc.Name(), want, err)
+	}
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if !expected.Has(fields.Get(i)) {
+			t.Fatalf("%s leaves out field %s, set every field", want, fields.Get(i).Name())
+		}
+	}
+
+	got := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(PT(&v).MarshalProto(), got); err != nil {
+		t.Fatalf("%T encodes an invalid %s: %v", v, desc.Name(), err)
+	}
+	if unknown := got.GetUnknown(); len(unknown) > 0 {
+		t.Errorf("%T encodes fields that %s does not declare: %x", v, desc.Name(), unknown)
+	}
+	if !proto.Equal(got, expected) {
+		t.Errorf("%T encodes %v, want %v", v, got, expected)
+	}
+
+	data, err := proto.Marshal(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded T
+	if err := PT(&decoded).UnmarshalProto(data); err != nil {
+		t.Fatalf("%T fails to decode %s: %v", v, desc.Name(), err)
+	}
+	if !reflect.DeepEqual(decoded, v) {
+		t.Errorf("%T decodes %s as %+v, want %+v", v, desc.Name(), decoded, v)
+	}
+}
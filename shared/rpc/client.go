@@ -0,0 +1,216 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lucas/shared/codec"
+	"github.com/lucas/shared/consumer"
+	"github.com/lucas/shared/utils"
+	"github.com/segmentio/kafka-go"
+)
+
+// Client calls services over Kafka. One client serves every call of a process
+// instance: replies for all of them arrive on its reply topic, which no other
+// instance reads and which is deleted when the instance shuts down.
+type Client struct {
+	broker      string
+	replyTopic  string
+	timeout     time.Duration
+	contentType string
+	writer      *kafka.Writer
+
+	mu      sync.Mutex
+	pending map[string]chan kafka.Message
+}
+
+// NewClient returns a client receiving replies on replyTopic. Calls whose
+// context has no deadline give up after timeout. Payloads are encoded as set
+// by WIRE_FORMAT when their type supports it. Run must be started before the
+// first call.
+func NewClient(broker, replyTopic string, timeout time.Duration) *Client {
+	return &Client{
+		broker:      broker,
+		replyTopic:  replyTopic,
+		timeout:     timeout,
+		contentType: codec.Preferred(),
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(broker),
+			Balancer: &kafka.LeastBytes{},
+		},
+		pending: make(map[string]chan kafka.Message),
+	}
+}
+
+// Run creates the reply topic and consumes it until ctx is cancelled, then
+// deletes it. Cancel ctx only once no call is in progress.
+func (c *Client) Run(ctx context.Context) {
+	defer c.writer.Close()
+
+	ensureReplyTopic(c.broker, c.replyTopic)
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{c.broker},
+		Topic:       c.replyTopic,
+		GroupID:     c.replyTopic,
+		StartOffset: kafka.LastOffset, // Replies sent before startup have no one waiting for them
+	})
+
+	deliver := func(_ context.Context, message kafka.Message) ([]byte, error) {
+		c.deliver(message)
+		return nil, nil
+	}
+	consumer.New("RPC client replies", reader, deliver).Run(ctx)
+
+	// Instances come and go with new names, so their topics would pile up.
+	// One left by a crash expires its replies but stays until deleted by hand.
+	if err := utils.DeleteKafkaTopic(c.broker, c.replyTopic); err != nil && !errors.Is(err, kafka.UnknownTopicOrPartition) {
+		log.Printf("Failed to delete reply topic %s: %v", c.replyTopic, err)
+		return
+	}
+	log.Printf("Deleted reply topic %s", c.replyTopic)
+}
+
+// Call sends req to action of service and decodes the reply into Resp. It
+// returns an *Error when the handler failed or the call timed out, and
+// ctx.Err() when ctx was cancelled first.
+func Call[Req, Resp any](ctx context.Context, c *Client, service, action string, req Req) (Resp, error) {
+	var resp Resp
+
+	payload, contentType, err := codec.Marshal(c.contentType, req)
+	if err != nil {
+		return resp, fmt.Errorf("failed to marshal %s request: %w", action, err)
+	}
+
+	// Only ask for a reply encoding Resp can be decoded from
+	accept := codec.ContentTypeJSON
+	if codec.Supports(c.contentType, &resp) {
+		accept = c.contentType
+	}
+
+	reply, err := c.call(ctx, service, action, payload, contentType, accept)
+	if err != nil {
+		return resp, err
+	}
+	if err := codec.Unmarshal(codec.ContentTypeOf(reply), reply.Value, &resp); err != nil {
+		return resp, fmt.Errorf("failed to unmarshal %s reply: %w", action, err)
+	}
+	return resp, nil
+}
+
+func (c *Client) call(ctx context.Context, service, action string, payload []byte, contentType, accept string) (reply kafka.Message, err error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	deadline, _ := ctx.Deadline()
+
+	started := time.Now()
+	defer func() { observe(clientStats, service+"."+action, started, err) }()
+
+	correlationID := uuid.New().String()
+	headers := []kafka.Header{
+		{Key: HeaderCorrelationID, Value: []byte(correlationID)},
+		{Key: HeaderAction, Value: []byte(action)},
+		{Key: HeaderReplyTo, Value: []byte(c.replyTopic)},
+		{Key: HeaderDeadline, Value: []byte(deadline.UTC().Format(time.RFC3339Nano))},
+		{Key: codec.HeaderContentType, Value: []byte(contentType)},
+		{Key: HeaderAccept, Value: []byte(accept)},
+	}
+	for key, value := range metadataFrom(ctx) {
+		headers = append(headers, kafka.Header{Key: headerMetadata + key, Value: []byte(value)})
+	}
+
+	// Register before publishing so the reply can't arrive ahead of the waiter
+	ch := make(chan kafka.Message, 1)
+	c.mu.Lock()
+	c.pending[correlationID] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, correlationID)
+		c.mu.Unlock()
+	}()
+
+	err = c.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   RequestTopic(service),
+		Key:     []byte(correlationID),
+		Value:   payload,
+		Headers: headers,
+	})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return reply, ErrTimeout
+		}
+		return reply, fmt.Errorf("failed to send %s request: %w", action, err)
+	}
+
+	select {
+	case message := <-ch:
+		if code := header(message, HeaderErrorCode); code != "" {
+			var body struct {
+				Error string `json:"error"`
+			}
+			_ = json.Unmarshal(message.Value, &body)
+			return reply, &Error{Code: code, Message: body.Error}
+		}
+		return message, nil
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			log.Printf("Timeout waiting for %s response with correlationID: %s", action, correlationID)
+			return reply, ErrTimeout
+		}
+		return reply, ctx.Err()
+	}
+}
+
+func (c *Client) deliver(message kafka.Message) {
+	correlationID := header(message, HeaderCorrelationID)
+
+	c.mu.Lock()
+	ch, ok := c.pending[correlationID]
+	c.mu.Unlock()
+	if !ok {
+		log.Printf("Discarding reply with unknown or expired correlationID: %s", correlationID)
+		return
+	}
+
+	// The channel is buffered for one reply; duplicates are dropped
+	select {
+	case ch <- message:
+	default:
+		log.Printf("Discarding duplicate reply for correlationID: %s", correlationID)
+	}
+}
+
+// ensureReplyTopic creates the reply topic, retrying until Kafka is reachable.
+// A single partition keeps the topic cheap and retention is short since
+// replies are only useful for as long as a call is waiting.
+func ensureReplyTopic(broker, topic string) {
+	for retries := 0; retries < 30; retries++ {
+		err := utils.CreateKafkaTopic(broker, kafka.TopicConfig{
+			Topic:             topic,
+			NumPartitions:     1,
+			ReplicationFactor: 1,
+			ConfigEntries: []kafka.ConfigEntry{
+				{ConfigName: "retention.ms", ConfigValue: "3600000"},
+			},
+		})
+		if err == nil || errors.Is(err, kafka.TopicAlreadyExists) {
+			log.Printf("Reply topic %s is ready", topic)
+			return
+		}
+
+		log.Printf("Failed to create reply topic %s: %v. Retrying in 5 seconds...", topic, err)
+		time.Sleep(5 * time.Second)
+	}
+
+	log.Printf("Giving up creating reply topic %s, relying on broker auto-creation", topic)
+}
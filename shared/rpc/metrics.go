@@ -0,0 +1,42 @@
+package rpc
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Call statistics by "<service>.<action>", published with expvar under
+// rpc_client and rpc_server. Serve them with expvar.Handler.
+var (
+	clientStats = expvar.NewMap("rpc_client")
+	serverStats = expvar.NewMap("rpc_server")
+	statsMu     sync.Mutex
+)
+
+// observe counts a call or request and its outcome: calls, errors,
+// errors.<code> and seconds, the total time spent.
+func observe(stats *expvar.Map, name string, started time.Time, err error) {
+	action := actionStats(stats, name)
+	action.Add("calls", 1)
+	action.AddFloat("seconds", time.Since(started).Seconds())
+	if err != nil {
+		action.Add("errors", 1)
+		action.Add("errors."+errorCode(err), 1)
+	}
+}
+
+func actionStats(stats *expvar.Map, name string) *expvar.Map {
+	if action, ok := stats.Get(name).(*expvar.Map); ok {
+		return action
+	}
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if action, ok := stats.Get(name).(*expvar.Map); ok {
+		return action
+	}
+	action := new(expvar.Map).Init()
+	stats.Set(name, action)
+	return action
+}
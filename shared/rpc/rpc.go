@@ -0,0 +1,105 @@
+// Package rpc is request/reply over Kafka. A Client publishes requests to a
+// service's request topic and waits for the reply on its own reply topic; a
+// Server consumes the request topic and dispatches each request to the
+// handler registered for its action.
+//
+// Requests and replies carry their control fields in Kafka headers, the value
+// is only the payload. Payloads are JSON unless both sides support Protobuf
+// for the type (see package codec): the request names its own encoding in the
+// content-type header and the one the caller can read the reply in with
+// rpc-accept.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/lucas/shared/models"
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka headers set on requests and replies
+const (
+	HeaderCorrelationID = "rpc-correlation-id"
+	HeaderAction        = "rpc-action"
+	HeaderReplyTo       = "rpc-reply-to"
+	HeaderDeadline      = "rpc-deadline" // RFC 3339, the caller stops waiting then
+	HeaderErrorCode     = "rpc-error-code"
+	HeaderAccept        = "rpc-accept" // Content type the caller wants the reply in
+	headerMetadata      = "rpc-meta-"
+)
+
+// Metadata keys the gateway passes along with requests
+const (
+	MetadataClientIP  = "client-ip"
+	MetadataUserAgent = "user-agent"
+)
+
+// Error is a failed call: the code and message the handler failed with, or a
+// timeout on the caller's side.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) ErrorCode() string {
+	return e.Code
+}
+
+var (
+	ErrTimeout       = &Error{models.ErrCodeTimeout, "timeout waiting for response"}
+	errInvalidFormat = &Error{models.ErrCodeInvalidRequest, "Invalid request format"}
+	errUnknownAction = &Error{models.ErrCodeUnknownAction, "unknown action"}
+)
+
+// errorCode returns the code of err when it has one, internal otherwise
+func errorCode(err error) string {
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	return models.ErrCodeInternal
+}
+
+// RequestTopic is the topic a service receives its requests on, e.g.
+// user-requests for user-service.
+func RequestTopic(service string) string {
+	return strings.TrimSuffix(service, "-service") + "-requests"
+}
+
+type metadataKey struct{}
+
+// WithMetadata returns a context whose calls carry key=value to the handler.
+func WithMetadata(ctx context.Context, key, value string) context.Context {
+	metadata := map[string]string{key: value}
+	for k, v := range metadataFrom(ctx) {
+		if _, ok := metadata[k]; !ok {
+			metadata[k] = v
+		}
+	}
+	return context.WithValue(ctx, metadataKey{}, metadata)
+}
+
+// Metadata returns the value the caller attached for key, or "".
+func Metadata(ctx context.Context, key string) string {
+	return metadataFrom(ctx)[key]
+}
+
+func metadataFrom(ctx context.Context) map[string]string {
+	metadata, _ := ctx.Value(metadataKey{}).(map[string]string)
+	return metadata
+}
+
+func header(message kafka.Message, key string) string {
+	for _, h := range message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
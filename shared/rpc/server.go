@@ -0,0 +1,267 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lucas/shared/codec"
+	"github.com/lucas/shared/consumer"
+	"github.com/lucas/shared/dedupe"
+	"github.com/lucas/shared/models"
+	"github.com/segmentio/kafka-go"
+)
+
+type handlerFunc func(ctx context.Context, contentType string, payload []byte) (any, error)
+
+// Server answers the requests sent to a service.
+type Server struct {
+	broker      string
+	service     string
+	concurrency int
+	writer      *kafka.Writer
+	handlers    map[string]handlerFunc
+	secret      map[string]bool // Actions whose replies are never recorded
+	dedupe      dedupe.Store
+}
+
+// NewServer returns a server for service handling up to concurrency requests
+// at a time. Register handlers with Handle before calling Serve.
+func NewServer(broker, service string, concurrency int) *Server {
+	return &Server{
+		broker:      broker,
+		service:     service,
+		concurrency: max(concurrency, 1),
+		// No fixed topic: replies go to the topic named by each request
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(broker),
+			Balancer: &kafka.LeastBytes{},
+		},
+		handlers: make(map[string]handlerFunc),
+		secret:   make(map[string]bool),
+	}
+}
+
+// WithDedupe makes the server handle each correlation ID once: a request
+// Kafka delivers again, or retried because its reply failed to send, is
+// answered with the reply recorded in store instead. Register actions
+// replying with credentials with HandleSecret so their replies only stay in
+// store until sent.
+func (s *Server) WithDedupe(store dedupe.Store) *Server {
+	s.dedupe = store
+	return s
+}
+
+// Handle registers fn as the handler of action. A payload that doesn't decode
+// into Req is answered with an invalid_request error without calling fn. An
+// error fn returns is answered with its code when it has an ErrorCode method,
+// as internal otherwise.
+func Handle[Req, Resp any](s *Server, action string, fn func(ctx context.Context, req Req) (Resp, error)) {
+	s.handlers[action] = func(ctx context.Context, contentType string, payload []byte) (any, error) {
+		var req Req
+		if err := codec.Unmarshal(contentType, payload, &req); err != nil {
+			return nil, errInvalidFormat
+		}
+		return fn(ctx, req)
+	}
+}
+
+// HandleSecret registers fn like Handle, for an action whose replies carry
+// credentials such as tokens or keys. Its replies are recorded until sent, so
+// a failed send is retried without running fn again, then erased: the
+// caller has the reply, and duplicates get none.
+func HandleSecret[Req, Resp any](s *Server, action string, fn func(ctx context.Context, req Req) (Resp, error)) {
+	Handle(s, action, fn)
+	s.secret[action] = true
+}
+
+// Serve consumes the service's request topic until ctx is cancelled, then
+// waits for the requests in progress to be answered. A request is committed
+// once it is answered; one that can't be is moved to the dead-letter topic of
+// the request topic.
+func (s *Server) Serve(ctx context.Context) {
+	defer s.writer.Close()
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     []string{s.broker},
+		Topic:       RequestTopic(s.service),
+		GroupID:     s.service + "-group",
+		StartOffset: kafka.FirstOffset, // Expired requests are skipped by their deadline
+	})
+
+	log.Printf("RPC server for %s started on topic %s", s.service, RequestTopic(s.service))
+
+	// Callers are waiting on the reply, so a failed request is only retried
+	// in place; retry topics would answer past the deadline
+	consumer.New(s.service+" RPC server", reader, s.handle).
+		WithConcurrency(s.concurrency).
+		WithRetryTopics().
+		Run(ctx)
+}
+
+// sentReply is a reply as recorded for duplicates of its request
+type sentReply struct {
+	Value   []byte         `json:"value"`
+	Headers []kafka.Header `json:"headers"`
+}
+
+// handle answers a request. With dedupe the reply is recorded before it is
+// sent, so a request whose reply can't be sent fails and is retried by
+// resending the recorded reply, without running the handler again. Requests
+// that can't be answered at all are dead-lettered.
+func (s *Server) handle(_ context.Context, message kafka.Message) ([]byte, error) {
+	correlationID := header(message, HeaderCorrelationID)
+	action := header(message, HeaderAction)
+	replyTo := header(message, HeaderReplyTo)
+	if correlationID == "" || replyTo == "" {
+		return nil, consumer.Permanent(fmt.Errorf("%s request without correlation ID or reply topic", action))
+	}
+
+	ctx := context.Background()
+	if deadline, err := time.Parse(time.RFC3339Nano, header(message, HeaderDeadline)); err == nil {
+		if time.Now().After(deadline) {
+			log.Printf("Discarding expired %s request with correlationID: %s", action, correlationID)
+			return nil, nil
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	for _, h := range message.Headers {
+		if key, ok := strings.CutPrefix(h.Key, headerMetadata); ok {
+			ctx = WithMetadata(ctx, key, string(h.Value))
+		}
+	}
+
+	if s.dedupe != nil {
+		// A request being handled elsewhere fails with ErrInProgress, which
+		// the consumer waits out
+		claimed, recorded, err := s.dedupe.Claim(context.Background(), correlationID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim %s request %s: %w", action, correlationID, err)
+		}
+		if !claimed {
+			return nil, s.resend(message, recorded)
+		}
+	}
+
+	log.Printf("Received %s request with correlationID: %s", action, correlationID)
+
+	started := time.Now()
+	var resp any
+	var err error
+	if handler, ok := s.handlers[action]; ok {
+		resp, err = handler(ctx, codec.ContentTypeOf(message), message.Value)
+	} else {
+		err = errUnknownAction
+	}
+	observe(serverStats, s.service+"."+action, started, err)
+
+	var headers []kafka.Header
+	// Error replies are always JSON
+	accept := header(message, HeaderAccept)
+	if err != nil {
+		code := errorCode(err)
+		log.Printf("%s request %s failed: %v (%s)", action, correlationID, err, code)
+
+		headers = append(headers, kafka.Header{Key: HeaderErrorCode, Value: []byte(code)})
+		resp = models.ErrorResponse{Error: err.Error(), Code: code}
+		accept = codec.ContentTypeJSON
+	}
+
+	value, contentType, err := codec.Marshal(accept, resp)
+	if err != nil {
+		s.release(correlationID)
+		return nil, consumer.Permanent(fmt.Errorf("failed to marshal %s reply: %w", action, err))
+	}
+	reply := sentReply{
+		Value:   value,
+		Headers: append(headers, kafka.Header{Key: codec.HeaderContentType, Value: []byte(contentType)}),
+	}
+
+	recorded := s.record(correlationID, reply)
+	if err := s.sendReply(message, reply); err != nil {
+		if !recorded {
+			s.release(correlationID)
+		}
+		return nil, err
+	}
+
+	// The caller has the credentials now; don't keep a copy around
+	if recorded && s.secret[action] {
+		if err := s.dedupe.Complete(context.Background(), correlationID, nil); err != nil {
+			log.Printf("Failed to erase %s reply %s: %v", action, correlationID, err)
+		}
+	}
+	return nil, nil
+}
+
+// record stores reply for duplicates of its request, reporting whether it
+// did. A reply that can't be recorded is still sent; the request is then
+// only protected by its claim.
+func (s *Server) record(correlationID string, reply sentReply) bool {
+	if s.dedupe == nil {
+		return false
+	}
+
+	value, err := json.Marshal(reply)
+	if err == nil {
+		err = s.dedupe.Complete(context.Background(), correlationID, value)
+	}
+	if err != nil {
+		log.Printf("Failed to record reply %s: %v", correlationID, err)
+		return false
+	}
+	return true
+}
+
+// release drops the claim on a request that wasn't answered, so it can be
+// handled again.
+func (s *Server) release(correlationID string) {
+	if s.dedupe == nil {
+		return
+	}
+	if err := s.dedupe.Release(context.Background(), correlationID); err != nil {
+		log.Printf("Failed to release request %s: %v", correlationID, err)
+	}
+}
+
+// resend answers a duplicate of a request already handled with the reply
+// recorded for it.
+func (s *Server) resend(message kafka.Message, recorded []byte) error {
+	var reply sentReply
+	if len(recorded) == 0 || json.Unmarshal(recorded, &reply) != nil {
+		return nil
+	}
+	if deadline, err := time.Parse(time.RFC3339Nano, header(message, HeaderDeadline)); err == nil && time.Now().After(deadline) {
+		return nil
+	}
+
+	log.Printf("Resending reply to duplicate %s request with correlationID: %s",
+		header(message, HeaderAction), header(message, HeaderCorrelationID))
+	return s.sendReply(message, reply)
+}
+
+// sendReply writes reply to the reply topic of the request.
+func (s *Server) sendReply(request kafka.Message, reply sentReply) error {
+	correlationID := header(request, HeaderCorrelationID)
+	replyTo := header(request, HeaderReplyTo)
+	headers := append([]kafka.Header{{Key: HeaderCorrelationID, Value: []byte(correlationID)}}, reply.Headers...)
+
+	// The reply gets its own timeout so a handler that used up the deadline still answers
+	writeCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := s.writer.WriteMessages(writeCtx, kafka.Message{
+		Topic:   replyTo,
+		Key:     []byte(correlationID),
+		Value:   reply.Value,
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send %s reply to %s: %w", header(request, HeaderAction), replyTo, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// CreateKafkaTopic creates a topic through the cluster controller. It fails
+// with kafka.TopicAlreadyExists when the topic exists.
+func CreateKafkaTopic(broker string, config kafka.TopicConfig) error {
+	controllerConn, err := dialController(broker)
+	if err != nil {
+		return err
+	}
+	defer controllerConn.Close()
+
+	return controllerConn.CreateTopics(config)
+}
+
+// DeleteKafkaTopic deletes a topic through the cluster controller. It fails
+// with kafka.UnknownTopicOrPartition when the topic doesn't exist.
+func DeleteKafkaTopic(broker, topic string) error {
+	controllerConn, err := dialController(broker)
+	if err != nil {
+		return err
+	}
+	defer controllerConn.Close()
+
+	return controllerConn.DeleteTopics(topic)
+}
+
+func dialController(broker string) (*kafka.Conn, error) {
+	conn, err := kafka.Dial("tcp", broker)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	controller, err := conn.Controller()
+	if err != nil {
+		return nil, err
+	}
+
+	return kafka.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+}
@@ -2,6 +2,9 @@ package utils
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func GetEnvOrDefault(env string, def string) string {
@@ -11,3 +14,46 @@ func GetEnvOrDefault(env string, def string) string {
 	}
 	return envTry
 }
+
+func GetEnvDurationOrDefault(env string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(env))
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// GetEnvListOrDefault splits a comma-separated variable, ignoring blank entries.
+func GetEnvListOrDefault(env string, def []string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(env), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
+
+func GetEnvBoolOrDefault(env string, def bool) bool {
+	b, err := strconv.ParseBool(os.Getenv(env))
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// GetInstanceID identifies this replica of a service. In Kubernetes
+// INSTANCE_ID is set to the pod name; elsewhere the hostname is used.
+func GetInstanceID() string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "local"
+	}
+	return hostname
+}